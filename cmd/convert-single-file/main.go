@@ -2,99 +2,278 @@ package main
 
 import (
   "bufio"
+  "bytes"
   "encoding/json"
+  "errors"
   "fmt"
   "io"
+  "io/ioutil"
   "log"
   "os"
   "os/exec"
   "os/signal"
   "regexp"
+  "strconv"
   "strings"
   "syscall"
   "time"
+
+  "github.com/overview/overview-convert-framework/converter"
+  "github.com/overview/overview-convert-framework/internal/doconvert"
+  "github.com/overview/overview-convert-framework/internal/limits"
+  "github.com/overview/overview-convert-framework/schema"
 )
 
 var pagesProgressRegex = regexp.MustCompile("^c(\\d+)/(\\d+)$")
 var bytesProgressRegex = regexp.MustCompile("^b(\\d+)/(\\d+)$")
-var fractionProgressRegex = regexp.MustCompile("^0(?:.\\d+)?$")
-const HeartbeatDelay = 1500 * time.Millisecond // how long to wait before sending heartbeat
-
-type Task struct {
-  Blob struct {
-    NBytes int64 `json:nBytes`
-  } `json:blob`
+var emitRegex = regexp.MustCompile("^emit (\\d+)$")
+var outputFilenameRegex = regexp.MustCompile("^(0|[1-9]\\d*)(\\.json|\\.blob|-thumbnail\\.jpg|-thumbnail\\.png|\\.txt)$")
+
+// stdoutIsJsonLines is true if CONVERT_STDOUT_FORMAT=json-lines: that is,
+// do-convert-single-file writes one JSON object per line.
+var stdoutIsJsonLines bool
+
+// sendWarnings is true if CONVERT_WARNING_FRAGMENTS=true: that is, we output
+// do-convert-single-file's warnings to Overview as well as to stderr.
+var sendWarnings bool
+
+// streamInputToConvert is true if CONVERT_STREAM_INPUT=true: that is, we
+// pipe input to do-convert-single-file's stdin while writing input.blob.
+var streamInputToConvert bool
+
+// JsonLine is a line of do-convert-single-file's stdout, when
+// stdoutIsJsonLines. Each field is optional.
+type JsonLine struct {
+  Log *string `json:"log"`            // message for stderr
+  Warning *string `json:"warning"`    // message for stderr (and maybe Overview)
+  Progress json.RawMessage `json:"progress"` // e.g., 0.5 or {"children":{"nProcessed":1,"nTotal":3}}
+  Emit *int `json:"emit"`             // like "emit N"
+  Error *string `json:"error"`        // fatal error message for Overview
 }
 
-func prepareTempDir(tempDir string) {
-  if err := os.RemoveAll(tempDir); err != nil {
-    log.Fatalf("Failed to empty %s: %s", tempDir, err)
-  }
+// Task is the input JSON. We pass it to do-convert-single-file verbatim.
+type Task = schema.Task
 
-  if err := os.MkdirAll(tempDir, 0755); err != nil {
-    log.Fatalf("Failed to create %s: %s", tempDir, err)
-  }
-}
+// fractionProgress makes sure fractional progress stays between 0 and 1 and
+// never moves backward.
+var fractionProgress = converter.FractionProgress{Source: "do-convert-single-file"}
+
+// nOutputsEmitted is how many output documents we've printed because
+// do-convert-single-file wrote "emit N". (We've deleted their files.)
+var nOutputsEmitted int
 
-func writeInputBlob(inputJson string, tempDir string, mimeBoundary string) {
-  var task Task
-  if err := json.NewDecoder(strings.NewReader(inputJson)).Decode(&task); err != nil {
-    log.Fatalf("Could not parse input JSON: %s", err)
+// parseTask parses the input JSON. If it is invalid, it outputs an error and
+// exits.
+func parseTask(inputJson string, mimeBoundary string) Task {
+  task, err := schema.ParseTask([]byte(inputJson))
+  if err != nil {
+    printErrorAndExit("Invalid input JSON: " + err.Error(), mimeBoundary)
   }
+  return task
+}
 
+func createInputBlob(tempDir string) *os.File {
   blobFile, err := os.OpenFile(tempDir + "/input.blob", os.O_CREATE|os.O_WRONLY, 0644)
   if err != nil {
-    log.Fatalf("Could not open %s/input.blob for writing: %s", tempDir, err)
+    doconvert.Fatalf("Could not open %s/input.blob for writing: %s", tempDir, err)
   }
+  return blobFile
+}
+
+func writeInputBlob(task Task, tempDir string, mimeBoundary string) {
+  verifier := converter.NewInputVerifier(task.Blob)
+  blobFile := createInputBlob(tempDir)
   defer blobFile.Close()
 
-  nBytes, err := io.Copy(blobFile, os.Stdin)
-  if err != nil {
-    log.Fatalf("Could not copy from stdin to %s/input.blob: %s", tempDir, err)
+  if _, err := io.Copy(io.MultiWriter(blobFile, verifier), os.Stdin); err != nil {
+    doconvert.Fatalf("Could not copy from stdin to %s/input.blob: %s", tempDir, err)
   }
 
-  if nBytes != task.Blob.NBytes {
-    message := fmt.Sprintf("Input had wrong length: read %d bytes, but input JSON specified %d bytes", nBytes, task.Blob.NBytes)
+  if message := verifier.ErrorMessage(); message != "" {
     printErrorAndExit(message, mimeBoundary)
   }
 }
 
+// forgivingWriter writes to `writer` until it fails, and then ignores all
+// further writes. (do-convert-single-file may exit before it reads all its
+// input; we still want to finish writing input.blob.)
+type forgivingWriter struct {
+  writer io.Writer
+  failed bool
+}
+
+func (w *forgivingWriter) Write(p []byte) (int, error) {
+  if !w.failed {
+    if _, err := w.writer.Write(p); err != nil {
+      w.failed = true
+    }
+  }
+  return len(p), nil
+}
+
+// streamInput copies stdin to both input.blob and do-convert-single-file's
+// stdin. Once all is written, it closes both and sends `done` an error
+// message (or "" if the input was valid).
+func streamInput(verifier *converter.InputVerifier, tempDir string, convertStdin io.WriteCloser, done chan<- string) {
+  blobFile := createInputBlob(tempDir)
+
+  if _, err := io.Copy(io.MultiWriter(blobFile, verifier, &forgivingWriter{writer: convertStdin}), os.Stdin); err != nil {
+    doconvert.Fatalf("Could not copy from stdin to %s/input.blob: %s", tempDir, err)
+  }
+
+  blobFile.Close()
+  convertStdin.Close() // input.blob is complete before do-convert-single-file sees EOF
+  done <- verifier.ErrorMessage()
+}
+
+// fragmentWriter writes fragments to doconvert.Stdout.
+func fragmentWriter(mimeBoundary string) *converter.FragmentWriter {
+  return &converter.FragmentWriter{Writer: doconvert.Stdout, MimeBoundary: mimeBoundary}
+}
+
 func printFragment(name string, contents string, mimeBoundary string) {
-  if _, err := os.Stdout.Write([]byte("--" + mimeBoundary + "\r\nContent-Disposition: form-data; name=" + name + "\r\n\r\n" + contents + "\r\n")); err != nil {
-    log.Fatalf("Error writing: %s", err)
+  if err := fragmentWriter(mimeBoundary).WriteStringFragment(name, contents); err != nil {
+    doconvert.Fatalf("Error writing: %s", err)
+  }
+}
+
+// printWarning logs a warning from do-convert-single-file. If sendWarnings,
+// it outputs a "warning" fragment, too.
+func printWarning(message string, mimeBoundary string) {
+  log.Printf("do-convert-single-file: warning: %s", message)
+  if sendWarnings {
+    printFragment("warning", message, mimeBoundary)
   }
 }
 
-func printLineAsFragment(line string, mimeBoundary string) {
-  if g := pagesProgressRegex.FindStringSubmatch(line); g != nil {
+func printLineAsFragment(line string, tempDir string, mimeBoundary string) {
+  if strings.HasPrefix(line, "#") {
+    log.Printf("do-convert-single-file: %s", strings.TrimSpace(line[1:]))
+  } else if strings.HasPrefix(line, "warn:") {
+    printWarning(strings.TrimSpace(line[len("warn:"):]), mimeBoundary)
+  } else if g := emitRegex.FindStringSubmatch(line); g != nil {
+    emitOutput(g[1], tempDir, mimeBoundary)
+  } else if g := pagesProgressRegex.FindStringSubmatch(line); g != nil {
     printFragment("progress", "{\"children\":{\"nProcessed\":" + g[1] + ",\"nTotal\":" + g[2] + "}}", mimeBoundary)
   } else if g := bytesProgressRegex.FindStringSubmatch(line); g != nil {
     printFragment("progress", "{\"bytes\":{\"nProcessed\":" + g[1] + ",\"nTotal\":" + g[2] + "}}", mimeBoundary)
-  } else if fractionProgressRegex.MatchString(line) {
-    printFragment("progress", line, mimeBoundary)
+  } else if converter.LooksLikeFraction(line) {
+    fraction, err := fractionProgress.Parse(line)
+    if err != nil {
+      printErrorAndExit(err.Error(), mimeBoundary)
+    }
+    printFragment("progress", converter.FormatFraction(fraction), mimeBoundary)
   } else {
     printErrorAndExit(line, mimeBoundary)
   }
 }
 
+// ProgressCount is the value in a json-lines progress object, like
+// {"children":{"nProcessed":1,"nTotal":3}}.
+type ProgressCount struct {
+  NProcessed *int64 `json:"nProcessed"`
+  NTotal *int64 `json:"nTotal"`
+}
+
+// parseProgressObject checks a json-lines progress object and returns the
+// progress fragment to output: {"children":{...}} or {"bytes":{...}}, with
+// 0 <= nProcessed <= nTotal.
+func parseProgressObject(rawProgress json.RawMessage) (string, error) {
+  var progress map[string]json.RawMessage
+  if err := json.Unmarshal(rawProgress, &progress); err != nil {
+    return "", err
+  }
+  if len(progress) != 1 {
+    return "", errors.New("want exactly one of \"children\" or \"bytes\"")
+  }
+
+  for unit, rawCount := range progress {
+    if unit != "children" && unit != "bytes" {
+      return "", fmt.Errorf("%q is not \"children\" or \"bytes\"", unit)
+    }
+
+    var count ProgressCount
+    decoder := json.NewDecoder(bytes.NewReader(rawCount))
+    decoder.DisallowUnknownFields()
+    if err := decoder.Decode(&count); err != nil {
+      return "", err
+    }
+    if count.NProcessed == nil || count.NTotal == nil {
+      return "", errors.New("want \"nProcessed\" and \"nTotal\"")
+    }
+    if *count.NProcessed < 0 || *count.NProcessed > *count.NTotal {
+      return "", errors.New("want 0 <= nProcessed <= nTotal")
+    }
+    return fmt.Sprintf("{\"%s\":{\"nProcessed\":%d,\"nTotal\":%d}}", unit, *count.NProcessed, *count.NTotal), nil
+  }
+  panic("unreachable")
+}
+
+// printJsonLineAsFragment handles a line of output when stdoutIsJsonLines.
+//
+// Lines that aren't JSON objects are logged, not treated as errors: a stray
+// print statement shouldn't make the conversion fail.
+func printJsonLineAsFragment(line string, tempDir string, mimeBoundary string) {
+  var jsonLine JsonLine
+  if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &jsonLine) != nil {
+    log.Printf("do-convert-single-file: %s", line)
+    return
+  }
+
+  if jsonLine.Log != nil {
+    log.Printf("do-convert-single-file: %s", *jsonLine.Log)
+  }
+  if jsonLine.Warning != nil {
+    printWarning(*jsonLine.Warning, mimeBoundary)
+  }
+  if jsonLine.Progress != nil {
+    var progress interface{}
+    json.Unmarshal(jsonLine.Progress, &progress) // we know it's valid JSON
+    switch value := progress.(type) {
+    case float64:
+      if fraction, err := fractionProgress.Track(value, string(jsonLine.Progress)); err != nil {
+        log.Printf("Ignoring progress: %s", err)
+      } else {
+        printFragment("progress", converter.FormatFraction(fraction), mimeBoundary)
+      }
+    case map[string]interface{}:
+      if fragment, err := parseProgressObject(jsonLine.Progress); err != nil {
+        log.Printf("do-convert-single-file: ignoring invalid progress %s: %s", string(jsonLine.Progress), err)
+      } else {
+        printFragment("progress", fragment, mimeBoundary)
+      }
+    default:
+      log.Printf("do-convert-single-file: ignoring progress that is not a number or object: %s", string(jsonLine.Progress))
+    }
+  }
+  if jsonLine.Emit != nil {
+    emitOutput(strconv.Itoa(*jsonLine.Emit), tempDir, mimeBoundary)
+  }
+  if jsonLine.Error != nil {
+    printErrorAndExit(*jsonLine.Error, mimeBoundary)
+  }
+}
+
 func printHeartbeatFragment(mimeBoundary string) {
-  if _, err := os.Stdout.Write([]byte("--" + mimeBoundary + "\r\nContent-Disposition: form-data; name=heartbeat\r\n\r\n\r\n")); err != nil {
-    log.Fatalf("Error writing: %s", err)
+  printFragment("heartbeat", "", mimeBoundary)
+}
+
+// printLastFragmentAndExit prints a fragment and the close delimiter, and
+// then exits.
+func printLastFragmentAndExit(name string, contents string, mimeBoundary string) {
+  printFragment(name, contents, mimeBoundary)
+  if err := fragmentWriter(mimeBoundary).WriteCloseDelimiter(); err != nil {
+    doconvert.Fatalf("Error writing: %s", err)
   }
+  doconvert.Exit(0)
 }
 
 func printErrorAndExit(message string, mimeBoundary string) {
-  if _, err := os.Stdout.Write([]byte("--" + mimeBoundary + "\r\nContent-Disposition: form-data; name=error\r\n\r\n" + message + "\r\n--" + mimeBoundary + "--")); err != nil {
-    log.Fatalf("Error writing: %s", err)
-  }
-  os.Exit(0)
+  printLastFragmentAndExit("error", message, mimeBoundary)
 }
 
 func printDoneAndExit(mimeBoundary string) {
-  if _, err := os.Stdout.Write([]byte("--" + mimeBoundary + "\r\nContent-Disposition: form-data; name=done\r\n\r\n\r\n--" + mimeBoundary + "--")); err != nil {
-    log.Fatalf("Error writing: %s", err)
-  }
-  os.Exit(0)
+  printLastFragmentAndExit("done", "", mimeBoundary)
 }
 
 func printFileAsFragment(tempDir string, path string, mimeBoundary string) {
@@ -102,15 +281,13 @@ func printFileAsFragment(tempDir string, path string, mimeBoundary string) {
   if err != nil {
     printErrorAndExit("do-convert-single-file did not output " + path, mimeBoundary)
   }
+  defer file.Close()
 
-  if _, err := os.Stdout.Write([]byte("--" + mimeBoundary + "\r\nContent-Disposition: form-data; name=" + path + "\r\n\r\n")); err != nil {
-    log.Fatalf("Error writing: %s", err)
+  if err := fragmentWriter(mimeBoundary).WriteHeader(path); err != nil {
+    doconvert.Fatalf("Error writing: %s", err)
   }
-  if _, err := io.Copy(os.Stdout, file); err != nil {
-    log.Fatalf("Error copying %s: %s", path, err)
-  }
-  if _, err := os.Stdout.Write([]byte("\r\n")); err != nil {
-    log.Fatalf("Error writing: %s", err)
+  if _, err := io.Copy(doconvert.Stdout, file); err != nil {
+    doconvert.Fatalf("Error copying %s: %s", path, err)
   }
 }
 
@@ -120,11 +297,93 @@ func printFileAsFragmentIfExists(tempDir string, path string, mimeBoundary strin
   }
 }
 
+// countOutputs returns how many output documents do-convert-single-file
+// wrote: N, if the highest-numbered output file is "(N-1).json",
+// "(N-1).blob", "(N-1)-thumbnail.png", etc. It returns at least `minimum`.
+func countOutputs(tempDir string, minimum int) int {
+  files, err := ioutil.ReadDir(tempDir)
+  if err != nil {
+    doconvert.Fatalf("Could not list %s: %s", tempDir, err)
+  }
+
+  nOutputs := minimum
+  for _, file := range files {
+    if g := outputFilenameRegex.FindStringSubmatch(file.Name()); g != nil {
+      n, err := strconv.Atoi(g[1])
+      if err == nil && n + 1 > nOutputs {
+        nOutputs = n + 1
+      }
+    }
+  }
+  return nOutputs
+}
+
+// printOutput prints N.json, N.blob, and (if they exist) N-thumbnail.jpg,
+// N-thumbnail.png and N.txt.
+func printOutput(tempDir string, n string, mimeBoundary string) {
+  printFileAsFragment(tempDir, n + ".json", mimeBoundary)
+  printFileAsFragment(tempDir, n + ".blob", mimeBoundary)
+  printFileAsFragmentIfExists(tempDir, n + "-thumbnail.jpg", mimeBoundary)
+  printFileAsFragmentIfExists(tempDir, n + "-thumbnail.png", mimeBoundary)
+  printFileAsFragmentIfExists(tempDir, n + ".txt", mimeBoundary)
+}
+
+// emitOutput handles "emit N" from do-convert-single-file: it prints output N
+// and then deletes its files, so they don't fill the disk.
+//
+// Outputs must be emitted in order, starting at 0.
+func emitOutput(n string, tempDir string, mimeBoundary string) {
+  if n != strconv.Itoa(nOutputsEmitted) {
+    printErrorAndExit(fmt.Sprintf("do-convert-single-file wrote \"emit %s\", but the next output is %d", n, nOutputsEmitted), mimeBoundary)
+  }
+
+  requireOutput(tempDir, n, mimeBoundary)
+  printOutput(tempDir, n, mimeBoundary)
+
+  for _, suffix := range []string{".json", ".blob", "-thumbnail.jpg", "-thumbnail.png", ".txt"} {
+    if err := os.Remove(tempDir + "/" + n + suffix); err != nil && !os.IsNotExist(err) {
+      doconvert.Fatalf("Could not delete %s%s: %s", n, suffix, err)
+    }
+  }
+
+  nOutputsEmitted++
+}
+
+// requireOutput outputs an error and exits unless N.json and N.blob exist.
+func requireOutput(tempDir string, n string, mimeBoundary string) {
+  for _, path := range []string{n + ".json", n + ".blob"} {
+    if _, err := os.Stat(tempDir + "/" + path); err != nil {
+      printErrorAndExit("do-convert-single-file did not output " + path, mimeBoundary)
+    }
+  }
+}
+
+// printOutputs prints each output do-convert-single-file did not emit, in
+// order.
+//
+// The sequence must be contiguous: if there's a 2.json, there must be a 1.json
+// and 1.blob. We check every output before printing any, so Overview never
+// receives half a document followed by an error.
+func printOutputs(tempDir string, mimeBoundary string) {
+  minimum := nOutputsEmitted
+  if minimum == 0 {
+    minimum = 1 // 0.json and 0.blob are required
+  }
+
+  nOutputs := countOutputs(tempDir, minimum)
+  for i := nOutputsEmitted; i < nOutputs; i++ {
+    requireOutput(tempDir, strconv.Itoa(i), mimeBoundary)
+  }
+  for i := nOutputsEmitted; i < nOutputs; i++ {
+    printOutput(tempDir, strconv.Itoa(i), mimeBoundary)
+  }
+}
+
 // returns once `convertStdout` has been consumed to EOF
 //
 // On a timeout, repeats the last message -- which we assume is a progress
 // message.
-func printProgressAndErrorOnStdout(convertStdout io.Reader, mimeBoundary string) {
+func printProgressAndErrorOnStdout(convertStdout io.Reader, tempDir string, mimeBoundary string) {
   lines := make(chan string, 1)
 
   // Happy path: read from convertStdout, writing to `lines`
@@ -146,17 +405,23 @@ func printProgressAndErrorOnStdout(convertStdout io.Reader, mimeBoundary string)
         // We're done!
         return
       }
-      printLineAsFragment(line, mimeBoundary)
+      if stdoutIsJsonLines {
+        printJsonLineAsFragment(line, tempDir, mimeBoundary)
+      } else {
+        printLineAsFragment(line, tempDir, mimeBoundary)
+      }
 
     // If a line takes too long, we don't want the HTTP connection to time
     // out. Send a heartbeat.
-    case <-time.After(HeartbeatDelay):
+    case <-time.After(converter.HeartbeatDelay):
       printHeartbeatFragment(mimeBoundary)
     }
   }
 }
 
-func runConvert(mimeBoundary string, inputJson string, tempDir string) {
+// runConvert runs do-convert-single-file. If task is not nil, it streams
+// input to do-convert-single-file as it writes input.blob.
+func runConvert(mimeBoundary string, inputJson string, tempDir string, task *Task) {
   path := "/app/do-convert-single-file"
   args := make([]string, 2)
   args[0] = path
@@ -166,67 +431,122 @@ func runConvert(mimeBoundary string, inputJson string, tempDir string) {
     Args: args,
     Dir: tempDir,
     Stderr: os.Stderr,
+    // Run in a new process group, so we can signal everything it starts
+    SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+  }
+
+  convertLimits := doconvert.LimitsFromEnv()
+  if err := limits.WrapCommand(&cmd, convertLimits); err != nil {
+    printErrorAndExit(path + " does not exist or is not executable", mimeBoundary)
   }
 
   stdout, err := cmd.StdoutPipe()
   if err != nil {
-    log.Fatalf("Could not open stdout for read: %s", err)
+    doconvert.Fatalf("Could not open stdout for read: %s", err)
   }
 
-  interrupt := make(chan os.Signal, 1)
-  signal.Notify(interrupt, os.Interrupt)
+  var stdin io.WriteCloser
+  var verifier *converter.InputVerifier
+  inputDone := make(chan string, 1)
+  if task != nil {
+    verifier = converter.NewInputVerifier(task.Blob)
+    stdin, err = cmd.StdinPipe()
+    if err != nil {
+      doconvert.Fatalf("Could not open stdin for write: %s", err)
+    }
+  }
 
-  if err := cmd.Start(); err != nil {
+  if err := doconvert.Start(&cmd, "do-convert-single-file"); err != nil {
     if os.IsNotExist(err) {
       printErrorAndExit(path + " does not exist or is not executable", mimeBoundary)
     } else {
-      log.Fatalf("Could not start %s: %s", path, err)
+      doconvert.Fatalf("Could not start %s: %s", path, err)
     }
   }
 
-  go func() {
-    <-interrupt
-    cmd.Process.Signal(os.Interrupt)
-    cmd.Wait()
-    os.Exit(0)
-  }()
+  if task != nil {
+    go streamInput(verifier, tempDir, stdin, inputDone)
+  }
 
-  printProgressAndErrorOnStdout(stdout, mimeBoundary)
+  printProgressAndErrorOnStdout(stdout, tempDir, mimeBoundary)
 
-  if err = cmd.Wait(); err != nil {
+  err = doconvert.Wait()
+  if task != nil {
+    // Invalid input explains any other error
+    if message := <-inputDone; message != "" {
+      printErrorAndExit(message, mimeBoundary)
+    }
+  }
+  if err != nil {
     if exiterr, ok := err.(*exec.ExitError); ok {
       // Buggy program exited with nonzero.
       if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-        message := fmt.Sprintf("do-convert-single-file exited with status code %d", status.ExitStatus())
+        message := limits.ErrorMessage("do-convert-single-file", convertLimits, exiterr.ProcessState)
+        if message == "" {
+          message = fmt.Sprintf("do-convert-single-file exited with status code %d", status.ExitStatus())
+        }
         printErrorAndExit(message, mimeBoundary)
       } else {
-        log.Fatalf("Could not determine exit code")
+        doconvert.Fatalf("Could not determine exit code")
       }
     } else {
-      log.Fatalf("convert-single-file failed: %s", err)
+      doconvert.Fatalf("convert-single-file failed: %s", err)
     }
   }
 
-  printFileAsFragment(tempDir, "0.json", mimeBoundary)
-  printFileAsFragment(tempDir, "0.blob", mimeBoundary)
-  printFileAsFragmentIfExists(tempDir, "0-thumbnail.jpg", mimeBoundary)
-  printFileAsFragmentIfExists(tempDir, "0-thumbnail.png", mimeBoundary)
-  printFileAsFragmentIfExists(tempDir, "0.txt", mimeBoundary)
+  printOutputs(tempDir, mimeBoundary)
   printDoneAndExit(mimeBoundary)
 }
 
 func doConvert(mimeBoundary string, inputJson string, tempDir string) {
-  prepareTempDir(tempDir)
-  writeInputBlob(inputJson, tempDir, mimeBoundary)
-  runConvert(mimeBoundary, inputJson, tempDir)
+  task := parseTask(inputJson, mimeBoundary)
+  if streamInputToConvert {
+    runConvert(mimeBoundary, inputJson, tempDir, &task)
+  } else {
+    writeInputBlob(task, tempDir, mimeBoundary)
+    runConvert(mimeBoundary, inputJson, tempDir, nil)
+  }
 }
 
 func main() {
   log.SetFlags(0)
 
+  if os.Args[0] == limits.ExecArg0 {
+    limits.Exec(os.Args[1:])
+  }
+
   mimeBoundary := os.Args[1]
   inputJson := os.Args[2]
-  tempDir := os.TempDir() + "/overview-convert-single-file"
 
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  doconvert.GracePeriod = doconvert.GracePeriodFromEnv()
+  go doconvert.HandleInterrupt(interrupt)
+
+  switch format := os.Getenv("CONVERT_STDOUT_FORMAT"); format {
+  case "", "text":
+  case "json-lines":
+    stdoutIsJsonLines = true
+  default:
+    doconvert.Fatalf("Invalid CONVERT_STDOUT_FORMAT: %q is not \"text\" or \"json-lines\"", format)
+  }
+
+  if s := os.Getenv("CONVERT_WARNING_FRAGMENTS"); s != "" {
+    b, err := strconv.ParseBool(s)
+    if err != nil {
+      doconvert.Fatalf("Invalid CONVERT_WARNING_FRAGMENTS: %q is not \"true\" or \"false\"", s)
+    }
+    sendWarnings = b
+  }
+
+  if s := os.Getenv("CONVERT_STREAM_INPUT"); s != "" {
+    b, err := strconv.ParseBool(s)
+    if err != nil {
+      doconvert.Fatalf("Invalid CONVERT_STREAM_INPUT: %q is not \"true\" or \"false\"", s)
+    }
+    streamInputToConvert = b
+  }
+
+  tempDir := doconvert.CreateTempDir("overview-convert-single-file-")
   doConvert(mimeBoundary, inputJson, tempDir)
 }
@@ -11,39 +11,44 @@ import (
   "os/signal"
   "regexp"
   "syscall"
-)
 
-func prepareTempDir(tempDir string) {
-  if err := os.RemoveAll(tempDir); err != nil {
-    log.Fatalf("Failed to empty %s: %s", tempDir, err)
-  }
+  "github.com/overview/overview-convert-framework/converter"
+  "github.com/overview/overview-convert-framework/internal/doconvert"
+  "github.com/overview/overview-convert-framework/internal/limits"
+  "github.com/overview/overview-convert-framework/schema"
+)
 
-  if err := os.MkdirAll(tempDir, 0755); err != nil {
-    log.Fatalf("Failed to create %s: %s", tempDir, err)
+func outputOrCrash(b []byte) {
+  if _, err := doconvert.Stdout.Write(b); err != nil {
+    doconvert.Fatalf("Error writing to stdout: %s", err)
   }
 }
 
-func outputOrCrash(b []byte) {
-  if _, err := os.Stdout.Write(b); err != nil {
-    log.Fatalf("Error writing to stdout: %s", err)
-  }
+// fragmentWriter writes fragments to doconvert.Stdout.
+//
+// A fragment may be our first output, or it may follow
+// do-convert-stream-to-mime-multipart's. Either way, FragmentWriter starts it
+// with "\r\n--MIME-BOUNDARY", which works.
+func fragmentWriter(mimeBoundary string) *converter.FragmentWriter {
+  return &converter.FragmentWriter{Writer: doconvert.Stdout, MimeBoundary: mimeBoundary}
 }
 
 func printFragment(name string, contents string, mimeBoundary string) {
-  // This may be called before any other message. We'll still write the initial
-  // \r\n: it makes for an empty "preamble" in RFC2046, which is valid. And it
-  // makes this file's logic easier.
-  outputOrCrash([]byte("\r\n--" + mimeBoundary + "\r\nContent-Disposition: form-data; name=" + name + "\r\n\r\n" + contents))
+  if err := fragmentWriter(mimeBoundary).WriteStringFragment(name, contents); err != nil {
+    doconvert.Fatalf("Error writing to stdout: %s", err)
+  }
 }
 
 func printCloseDelimiter(mimeBoundary string) {
-  outputOrCrash([]byte("\r\n--" + mimeBoundary + "--"))
+  if err := fragmentWriter(mimeBoundary).WriteCloseDelimiter(); err != nil {
+    doconvert.Fatalf("Error writing to stdout: %s", err)
+  }
 }
 
 func printErrorAndExit(message string, mimeBoundary string) {
   printFragment("error", message, mimeBoundary)
   printCloseDelimiter(mimeBoundary)
-  os.Exit(0)
+  doconvert.Exit(0)
 }
 
 func min(x, y int) int {
@@ -66,32 +71,29 @@ func runConvert(mimeBoundary string, inputJson string, tempDir string) {
     Dir: tempDir,
     Stdin: os.Stdin,
     Stderr: os.Stderr,
+    // Run in a new process group, so we can signal everything it starts
+    SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+  }
+
+  convertLimits := doconvert.LimitsFromEnv()
+  if err := limits.WrapCommand(&cmd, convertLimits); err != nil {
+    printErrorAndExit(path + " does not exist or is not executable", mimeBoundary)
   }
 
   stdout, err := cmd.StdoutPipe()
   if err != nil {
-    log.Fatalf("Could not open stdout for read: %s", err)
+    doconvert.Fatalf("Could not open stdout for read: %s", err)
   }
   stdoutReader := bufio.NewReader(stdout)
 
-  interrupt := make(chan os.Signal, 1)
-  signal.Notify(interrupt, os.Interrupt)
-
-  if err := cmd.Start(); err != nil {
+  if err := doconvert.Start(&cmd, path); err != nil {
     if os.IsNotExist(err) {
       printErrorAndExit(path + " does not exist or is not executable", mimeBoundary)
     } else {
-      log.Fatalf("Could not start %s: %s", path, err)
+      doconvert.Fatalf("Could not start %s: %s", path, err)
     }
   }
 
-  go func() {
-    <-interrupt
-    cmd.Process.Signal(os.Interrupt)
-    cmd.Wait()
-    os.Exit(0)
-  }()
-
   // Copy output from program until there is none left.
   //
   // This blocks exactly the right amount of time. Possibilities:
@@ -122,7 +124,7 @@ func runConvert(mimeBoundary string, inputJson string, tempDir string) {
       break
     }
     if err != nil {
-      log.Fatalf("Error reading from %s: %v", path, err)
+      doconvert.Fatalf("Error reading from %s: %v", path, err)
     }
 
     // usefulBuffer: bytes of process output we'll scan for delimiter, in two
@@ -192,19 +194,23 @@ func runConvert(mimeBoundary string, inputJson string, tempDir string) {
     }
   }
 
-  if err = cmd.Wait(); err != nil {
+  err = doconvert.Wait()
+  if err != nil {
     if exiterr, ok := err.(*exec.ExitError); ok {
       // Buggy program exited with nonzero.
       if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
         if !wroteErrorOrDone {
-          message := fmt.Sprintf("%s exited with status code %d", path, status.ExitStatus())
+          message := limits.ErrorMessage(path, convertLimits, exiterr.ProcessState)
+          if message == "" {
+            message = fmt.Sprintf("%s exited with status code %d", path, status.ExitStatus())
+          }
           printErrorAndExit(message, mimeBoundary)
         }
       } else {
-        log.Fatalf("Could not determine exit code")
+        doconvert.Fatalf("Could not determine exit code")
       }
     } else {
-      log.Fatalf("convert-single-file failed: %s", err)
+      doconvert.Fatalf("convert-single-file failed: %s", err)
     }
   }
 
@@ -219,16 +225,30 @@ func runConvert(mimeBoundary string, inputJson string, tempDir string) {
 }
 
 func doConvert(mimeBoundary string, inputJson string, tempDir string) {
-  prepareTempDir(tempDir)
+  // We pass the input JSON along verbatim. Make sure it's valid, so
+  // do-convert-stream-to-mime-multipart doesn't have to.
+  if _, err := schema.ParseTask([]byte(inputJson)); err != nil {
+    printErrorAndExit("Invalid input JSON: " + err.Error(), mimeBoundary)
+  }
   runConvert(mimeBoundary, inputJson, tempDir)
 }
 
 func main() {
   log.SetFlags(0)
 
+  if os.Args[0] == limits.ExecArg0 {
+    limits.Exec(os.Args[1:])
+  }
+
   mimeBoundary := os.Args[1]
   inputJson := os.Args[2]
-  tempDir := os.TempDir() + "/overview-convert-stream-to-mime-multipart"
 
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  doconvert.GracePeriod = doconvert.GracePeriodFromEnv()
+  go doconvert.HandleInterrupt(interrupt)
+
+  tempDir := doconvert.CreateTempDir("overview-convert-stream-to-mime-multipart-")
   doConvert(mimeBoundary, inputJson, tempDir)
+  doconvert.Exit(0)
 }
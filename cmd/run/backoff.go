@@ -0,0 +1,39 @@
+package main
+
+import (
+  "math/rand"
+  "time"
+)
+
+// Backoff computes how long to wait before retrying after a transient error.
+//
+// Each consecutive failure doubles the wait, up to Max. We randomize each wait
+// between half and all of that ("equal jitter"), so a fleet of converters
+// doesn't hammer Overview in lockstep when it comes back up.
+type Backoff struct {
+  Initial time.Duration
+  Max time.Duration
+  nFailures int // consecutive failures since the last Reset()
+}
+
+// Next returns how long to wait before the next retry, and counts a failure.
+func (b *Backoff) Next() time.Duration {
+  d := b.Initial
+  for i := 0; i < b.nFailures && d < b.Max; i++ {
+    d *= 2
+  }
+  if d > b.Max {
+    d = b.Max
+  }
+  if d < b.Max {
+    b.nFailures++ // once we've reached Max, stop counting: no overflow
+  }
+
+  half := d / 2
+  return half + time.Duration(rand.Int63n(int64(d - half) + 1))
+}
+
+// Reset makes the next wait short again. Call it after a success.
+func (b *Backoff) Reset() {
+  b.nFailures = 0
+}
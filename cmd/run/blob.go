@@ -0,0 +1,195 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "net/http"
+  "sync"
+)
+
+const DefaultBlobDownloadAttempts = 5 // how many times we request a blob before giving up
+
+// BlobSource reads a blob from wherever Task.Blob.Url points: see
+// newBlobSource() for the URL schemes we support.
+type BlobSource interface {
+  // Open returns the blob, starting `offset` bytes in, and how many bytes
+  // that is -- or -1 if it can't tell.
+  Open(offset int64) (io.ReadCloser, int64, error)
+}
+
+// blobDownload streams a blob from a BlobSource.
+//
+// When Open() or Read() fails with an error that may go away -- the
+// connection drops, or the server responds 503 -- blobDownload waits (with
+// Backoff) and opens the rest of the blob, so the reader sees one unbroken
+// stream. It gives up after `maxAttempts` consecutive failures.
+//
+// It checks the blob's size against the task's blob.nBytes, when set, so
+// /app/convert never mistakes an error page for the blob.
+//
+// If it gives up mid-stream, it calls onFailure() (before /app/convert sees
+// the end of its input) and returns an error from Read().
+type blobDownload struct {
+  ctx context.Context // only for waiting between attempts
+  source BlobSource
+  nBytes int64        // expected size, or 0 if the task doesn't say
+  maxAttempts int
+  backoff Backoff
+  logger *log.Logger
+  onFailure func(errorMessage string)
+
+  reader io.ReadCloser // nil between attempts
+  offset int64         // bytes read so far
+  nFailures int        // consecutive failed attempts
+
+  lock sync.Mutex
+  errorMessage string
+}
+
+// blobStatusError means the server responded with an HTTP status we didn't
+// expect.
+type blobStatusError struct {
+  Status string // e.g., "403 Forbidden"
+  StatusCode int
+}
+
+func (e *blobStatusError) Error() string {
+  return e.Status
+}
+
+// isRetryableBlobError returns true if another attempt might succeed.
+//
+// Network trouble and server errors may go away. Other HTTP errors won't: a
+// presigned URL that is Forbidden now will be Forbidden next time, too.
+func isRetryableBlobError(err error) bool {
+  var statusErr *blobStatusError
+  if errors.As(err, &statusErr) {
+    return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+  }
+  return classifyHttpError(err) == Transient
+}
+
+// openBlob opens the task's blob, retrying as blobDownload does.
+func (w *Worker) openBlob(ctx context.Context, task Task) (*blobDownload, error) {
+  source, err := newBlobSource(task.Blob.Url, w.Config)
+  if err != nil {
+    return nil, err
+  }
+
+  blob := &blobDownload{
+    ctx: ctx,
+    source: source,
+    nBytes: task.Blob.NBytes,
+    maxAttempts: w.Config.BlobDownloadAttempts,
+    backoff: Backoff{
+      Initial: w.Config.RetryInitialInterval,
+      Max: w.Config.RetryMaxInterval,
+    },
+    logger: w.Logger,
+  }
+  if err := blob.open(); err != nil {
+    return nil, err
+  }
+  return blob, nil
+}
+
+// fail counts a failed attempt. It returns nil if we should try again, or
+// the error we should give up with.
+func (r *blobDownload) fail(err error) error {
+  r.nFailures++
+  if !isRetryableBlobError(err) {
+    return err
+  }
+  if r.nFailures >= r.maxAttempts {
+    return fmt.Errorf("%s (after %d attempts)", err, r.nFailures)
+  }
+
+  wait := r.backoff.Next()
+  r.logger.Printf("Reading blob after %d bytes: %s; will retry in %fs", r.offset, err, wait.Seconds())
+  sleep(r.ctx, wait)
+  return r.ctx.Err()
+}
+
+// open sets r.reader, retrying until it succeeds or we give up.
+func (r *blobDownload) open() error {
+  for {
+    reader, size, err := r.source.Open(r.offset)
+    if err == nil {
+      if r.nBytes > 0 && size >= 0 && r.offset + size != r.nBytes {
+        reader.Close()
+        return fmt.Errorf("blob has %d bytes, but task specified blob.nBytes %d", r.offset + size, r.nBytes)
+      }
+      r.reader = reader
+      return nil
+    }
+    if err := r.fail(err); err != nil {
+      return err
+    }
+  }
+}
+
+func (r *blobDownload) Read(p []byte) (int, error) {
+  for {
+    if r.reader == nil {
+      if err := r.open(); err != nil {
+        return 0, r.giveUp(err)
+      }
+    }
+
+    n, err := r.reader.Read(p)
+    r.offset += int64(n)
+    if n > 0 {
+      r.nFailures = 0
+      r.backoff.Reset()
+    }
+    if err == io.EOF && r.nBytes > 0 && r.offset != r.nBytes {
+      // Only possible when the source didn't know the size
+      return n, r.giveUp(fmt.Errorf("received %d bytes, but task specified blob.nBytes %d", r.offset, r.nBytes))
+    }
+    if err == nil || err == io.EOF {
+      return n, err
+    }
+
+    // The connection broke. Resume on the next Read().
+    r.reader.Close()
+    r.reader = nil
+    if err := r.fail(err); err != nil {
+      return n, r.giveUp(err)
+    }
+    if n > 0 {
+      return n, nil
+    }
+  }
+}
+
+// giveUp records err and calls onFailure(), unless we're shutting down:
+// then, watchForShutdown() reports the error.
+func (r *blobDownload) giveUp(err error) error {
+  if r.ctx.Err() != nil {
+    return err
+  }
+
+  message := "blob download failed: " + err.Error()
+  r.lock.Lock()
+  r.errorMessage = message
+  r.lock.Unlock()
+  r.onFailure(message)
+  return errors.New(message)
+}
+
+func (r *blobDownload) Close() error {
+  if r.reader == nil {
+    return nil
+  }
+  return r.reader.Close()
+}
+
+// ErrorMessage returns the download error, or "" if there was none (yet).
+func (r *blobDownload) ErrorMessage() string {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  return r.errorMessage
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+  "errors"
+  "io"
+  "sync"
+
+  "github.com/overview/overview-convert-framework/converter"
+)
+
+// checksumReader verifies the blob while we stream it to /app/convert.
+//
+// If the checksum is wrong, it calls onMismatch() (before /app/convert sees
+// the end of its input) and returns an error instead of io.EOF.
+type checksumReader struct {
+  reader io.ReadCloser
+  verifier *converter.ChecksumVerifier
+  onMismatch func(errorMessage string)
+
+  lock sync.Mutex
+  errorMessage string
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+  n, err := r.reader.Read(p)
+  r.verifier.Write(p[:n])
+  if err == io.EOF {
+    if message := r.verifier.ErrorMessage(); message != "" {
+      r.lock.Lock()
+      r.errorMessage = message
+      r.lock.Unlock()
+      r.onMismatch(message)
+      return n, errors.New(message)
+    }
+  }
+  return n, err
+}
+
+func (r *checksumReader) Close() error {
+  return r.reader.Close()
+}
+
+// ErrorMessage returns the checksum error, or "" if there was none (yet).
+func (r *checksumReader) ErrorMessage() string {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  return r.errorMessage
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+  "crypto/tls"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "strings"
+  "syscall"
+)
+
+// ErrorKind tells a Worker how to react to an error.
+type ErrorKind int
+
+const (
+  // Transient errors (connection refused, timeouts, 5xx, ...) may go away on
+  // their own. The worker waits, then polls again.
+  Transient ErrorKind = iota
+
+  // Permanent errors concern a single task (malformed JSON, a buggy
+  // /app/convert, ...). The worker logs them, skips the task and polls again
+  // right away.
+  Permanent
+)
+
+func (k ErrorKind) String() string {
+  switch k {
+  case Transient:
+    return "transient"
+  case Permanent:
+    return "permanent"
+  default:
+    return fmt.Sprintf("ErrorKind(%d)", int(k))
+  }
+}
+
+// RunError is an error that ends a tick. /app/run never crashes on one: it
+// logs it and carries on.
+type RunError struct {
+  Kind ErrorKind
+  Message string // what went wrong, e.g., "Could not download blob"
+  Err error      // underlying error, or nil
+}
+
+func (e *RunError) Error() string {
+  if e.Err == nil {
+    return e.Message
+  }
+  return e.Message + ": " + e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+  return e.Err
+}
+
+func transientError(err error, format string, v ...interface{}) *RunError {
+  return &RunError{Kind: Transient, Message: fmt.Sprintf(format, v...), Err: err}
+}
+
+func permanentError(err error, format string, v ...interface{}) *RunError {
+  return &RunError{Kind: Permanent, Message: fmt.Sprintf(format, v...), Err: err}
+}
+
+// httpError wraps an error from net/http, classifying it with
+// classifyHttpError().
+func httpError(err error, format string, v ...interface{}) *RunError {
+  return &RunError{Kind: classifyHttpError(err), Message: fmt.Sprintf(format, v...), Err: err}
+}
+
+var transientErrnos = []syscall.Errno{
+  syscall.ECONNREFUSED,
+  syscall.ECONNRESET,
+  syscall.ECONNABORTED,
+  syscall.EPIPE,
+  syscall.EHOSTUNREACH,
+  syscall.ENETUNREACH,
+  syscall.ETIMEDOUT,
+}
+
+// classifyHttpError decides whether an error from net/http is worth retrying.
+//
+// Network trouble is Transient: Overview may be restarting, or DNS may not
+// know about it yet. Anything we don't recognize is Permanent.
+func classifyHttpError(err error) ErrorKind {
+  var netErr net.Error
+  if errors.As(err, &netErr) && netErr.Timeout() {
+    return Transient
+  }
+
+  var dnsErr *net.DNSError
+  if errors.As(err, &dnsErr) {
+    return Transient // including "no such host"
+  }
+
+  for _, errno := range transientErrnos {
+    if errors.Is(err, errno) {
+      return Transient
+    }
+  }
+
+  if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+    return Transient // the server closed the connection
+  }
+
+  var recordHeaderErr tls.RecordHeaderError
+  if errors.As(err, &recordHeaderErr) || strings.Contains(err.Error(), "tls: ") || strings.Contains(err.Error(), "TLS handshake") {
+    return Transient
+  }
+
+  return Permanent
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// workerHealth is what one Worker is doing, as far as health checks care.
+type workerHealth struct {
+  tickStartTime time.Time       // when the worker last began polling
+  pollOk bool                   // true if the last poll reached Overview
+  conversionStartTime time.Time // zero when not converting
+  conversionDeadline time.Time  // zero when there is no deadline
+}
+
+// Health tracks our workers, so orchestrators can tell whether /app/run is
+// alive (`GET /healthz`) and ready (`GET /readyz`).
+//
+// /healthz fails if any worker is wedged: it hasn't begun polling in
+// Config.HealthMaxPollAge, or its conversion is past its deadline. /readyz
+// fails until a worker reaches Overview, and after shutdown begins.
+type Health struct {
+  lock sync.Mutex
+  maxPollAge time.Duration
+  workers []workerHealth // index is worker id - 1
+  shuttingDown bool
+}
+
+func NewHealth(config Config) *Health {
+  now := time.Now()
+  workers := make([]workerHealth, config.Concurrency)
+  for i := range workers {
+    workers[i].tickStartTime = now
+  }
+
+  return &Health{
+    maxPollAge: config.HealthMaxPollAge,
+    workers: workers,
+  }
+}
+
+func (h *Health) StartTick(workerId int) {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+  h.workers[workerId - 1].tickStartTime = time.Now()
+}
+
+// EndPoll records whether Overview responded to a poll.
+func (h *Health) EndPoll(workerId int, ok bool) {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+  h.workers[workerId - 1].pollOk = ok
+}
+
+// StartConversion records that a worker started /app/convert. A zero
+// deadline means "no deadline".
+func (h *Health) StartConversion(workerId int, deadline time.Time) {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+  h.workers[workerId - 1].conversionStartTime = time.Now()
+  h.workers[workerId - 1].conversionDeadline = deadline
+}
+
+func (h *Health) EndConversion(workerId int) {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+  h.workers[workerId - 1].conversionStartTime = time.Time{}
+  h.workers[workerId - 1].conversionDeadline = time.Time{}
+}
+
+func (h *Health) SetShuttingDown() {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+  h.shuttingDown = true
+}
+
+// livenessProblems returns why we aren't alive, or nil if we are.
+func (h *Health) livenessProblems(now time.Time) []string {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+
+  problems := []string{}
+  for i, worker := range h.workers {
+    if !worker.conversionStartTime.IsZero() {
+      if !worker.conversionDeadline.IsZero() && now.After(worker.conversionDeadline) {
+        problems = append(problems, fmt.Sprintf("worker %d has been converting for %s, past its deadline", i + 1, now.Sub(worker.conversionStartTime).Round(time.Second)))
+      }
+    } else if age := now.Sub(worker.tickStartTime); h.maxPollAge > 0 && age > h.maxPollAge {
+      problems = append(problems, fmt.Sprintf("worker %d has not polled for %s", i + 1, age.Round(time.Second)))
+    }
+  }
+  return problems
+}
+
+// readinessProblems returns why we aren't ready, or nil if we are.
+func (h *Health) readinessProblems() []string {
+  h.lock.Lock()
+  defer h.lock.Unlock()
+
+  if h.shuttingDown {
+    return []string{"shutting down"}
+  }
+
+  for _, worker := range h.workers {
+    if worker.pollOk {
+      return nil
+    }
+  }
+  return []string{"no worker has reached Overview"}
+}
+
+func writeHealthResponse(w http.ResponseWriter, problems []string) {
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  if len(problems) == 0 {
+    w.Write([]byte("ok\n"))
+  } else {
+    w.WriteHeader(http.StatusServiceUnavailable)
+    w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+  }
+}
+
+func (h *Health) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+  writeHealthResponse(w, h.livenessProblems(time.Now()))
+}
+
+func (h *Health) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+  writeHealthResponse(w, h.readinessProblems())
+}
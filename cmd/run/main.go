@@ -2,30 +2,176 @@ package main
 
 import (
   "bytes"
-  "encoding/json"
+  "context"
+  "errors"
+  "fmt"
+  "io"
   "io/ioutil"
   "log"
   "math/rand"
-  "net"
   "net/http"
   "net/url"
   "os"
   "os/exec"
+  "os/signal"
+  "strconv"
   "strings"
+  "sync"
+  "sync/atomic"
   "syscall"
   "time"
+
+  "github.com/overview/overview-convert-framework/converter"
+  "github.com/overview/overview-convert-framework/internal/s3"
+  "github.com/overview/overview-convert-framework/schema"
 )
 
-const retryTimeout = 3 * time.Second
+const DefaultRetryInitialInterval = 3 * time.Second // how long to wait after the first transient error
+const DefaultRetryMaxInterval = 60 * time.Second // the most we'll wait between retries
+const DefaultCancelPollInterval = 5 * time.Second // how often we ask Overview whether a task was canceled
+const DefaultShutdownGracePeriod = 10 * time.Second // how long /app/convert may run after we interrupt it on shutdown or timeout
+const DefaultHealthMaxPollAge = 5 * time.Minute // how long a worker may go without polling before /healthz fails
+
+// Config holds the settings we read from environment variables.
+type Config struct {
+  PollUrl string                   // POLL_URL
+  CancelPollInterval time.Duration // CANCEL_POLL_INTERVAL
+  Concurrency int                  // CONCURRENCY: number of workers
+  ShutdownGracePeriod time.Duration // SHUTDOWN_GRACE_PERIOD
+  ConvertGracePeriod time.Duration // CONVERT_GRACE_PERIOD: how long /app/convert-* lets do-convert-* run after we interrupt it
+  RetryInitialInterval time.Duration // RETRY_INITIAL_INTERVAL
+  RetryMaxInterval time.Duration // RETRY_MAX_INTERVAL
+  ListenAddress string // LISTEN_ADDRESS: where to serve /metrics, /healthz and /readyz, or "" to not
+  HealthMaxPollAge time.Duration // HEALTH_MAX_POLL_AGE
+  ConversionDeadline time.Duration // CONVERSION_DEADLINE, or 0 for none
+  ConversionDeadlinePerMB time.Duration // CONVERSION_DEADLINE_PER_MB: added to deadline per MiB of input
+  BlobDownloadAttempts int // BLOB_DOWNLOAD_ATTEMPTS: requests per blob before we give up
+  BlobFileDir string // BLOB_FILE_DIR: where file:// blob URLs may point, or "" to forbid them
+  S3 s3.Config // for s3:// blob URLs
+}
+
+func configFromEnv() Config {
+  config := Config{
+    PollUrl: os.Getenv("POLL_URL"),
+    ListenAddress: os.Getenv("LISTEN_ADDRESS"),
+    BlobFileDir: os.Getenv("BLOB_FILE_DIR"),
+    S3: s3.ConfigFromEnv(),
+    CancelPollInterval: DefaultCancelPollInterval,
+    Concurrency: 1,
+    ShutdownGracePeriod: DefaultShutdownGracePeriod,
+    RetryInitialInterval: DefaultRetryInitialInterval,
+    RetryMaxInterval: DefaultRetryMaxInterval,
+    HealthMaxPollAge: DefaultHealthMaxPollAge,
+    BlobDownloadAttempts: DefaultBlobDownloadAttempts,
+  }
+
+  if config.PollUrl == "" {
+    panic("You must set POLL_URL before calling this program")
+  }
+  if !isHttpUrl(config.PollUrl) {
+    panic("POLL_URL must be an HTTP(S) URL, like \"http://overview-web/api/v1/tasks\"")
+  }
+
+  if s := os.Getenv("CANCEL_POLL_INTERVAL"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil {
+      panic("CANCEL_POLL_INTERVAL must look like \"5s\" or \"500ms\" (or \"0\" to disable)")
+    }
+    config.CancelPollInterval = d
+  }
+
+  if s := os.Getenv("CONCURRENCY"); s != "" {
+    n, err := strconv.Atoi(s)
+    if err != nil || n < 1 {
+      panic("CONCURRENCY must be a positive integer")
+    }
+    config.Concurrency = n
+  }
+
+  if s := os.Getenv("SHUTDOWN_GRACE_PERIOD"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+      panic("SHUTDOWN_GRACE_PERIOD must look like \"10s\" or \"500ms\"")
+    }
+    config.ShutdownGracePeriod = d
+  }
+
+  convertGracePeriod, err := converter.GracePeriodFromEnv()
+  if err != nil {
+    panic(err.Error())
+  }
+  config.ConvertGracePeriod = convertGracePeriod
+
+  if config.ShutdownGracePeriod <= config.ConvertGracePeriod {
+    // We kill only /app/convert. If we kill it before it kills
+    // do-convert-*'s process group, those processes outlive the task.
+    panic("SHUTDOWN_GRACE_PERIOD must be greater than CONVERT_GRACE_PERIOD")
+  }
 
-type Task struct {
-  Url string `json:url`
-  Filename string `json:filename`
-  Blob struct {
-    Url string `json:url`
+  if s := os.Getenv("RETRY_INITIAL_INTERVAL"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d <= 0 {
+      panic("RETRY_INITIAL_INTERVAL must look like \"3s\" or \"500ms\"")
+    }
+    config.RetryInitialInterval = d
   }
+
+  if s := os.Getenv("RETRY_MAX_INTERVAL"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d <= 0 {
+      panic("RETRY_MAX_INTERVAL must look like \"60s\" or \"5m\"")
+    }
+    config.RetryMaxInterval = d
+  }
+
+  if config.RetryMaxInterval < config.RetryInitialInterval {
+    panic("RETRY_MAX_INTERVAL must not be less than RETRY_INITIAL_INTERVAL")
+  }
+
+  if s := os.Getenv("HEALTH_MAX_POLL_AGE"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+      panic("HEALTH_MAX_POLL_AGE must look like \"5m\" or \"90s\" (or \"0\" to disable)")
+    }
+    config.HealthMaxPollAge = d
+  }
+
+  if config.HealthMaxPollAge > 0 && config.HealthMaxPollAge <= config.RetryMaxInterval {
+    // A worker waiting to retry would look wedged
+    panic("HEALTH_MAX_POLL_AGE must be greater than RETRY_MAX_INTERVAL")
+  }
+
+  if s := os.Getenv("CONVERSION_DEADLINE"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+      panic("CONVERSION_DEADLINE must look like \"10m\" or \"1h\" (or \"0\" for none)")
+    }
+    config.ConversionDeadline = d
+  }
+
+  if s := os.Getenv("CONVERSION_DEADLINE_PER_MB"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+      panic("CONVERSION_DEADLINE_PER_MB must look like \"1s\" or \"500ms\" (or \"0\" for none)")
+    }
+    config.ConversionDeadlinePerMB = d
+  }
+
+  if s := os.Getenv("BLOB_DOWNLOAD_ATTEMPTS"); s != "" {
+    n, err := strconv.Atoi(s)
+    if err != nil || n < 1 {
+      panic("BLOB_DOWNLOAD_ATTEMPTS must be a positive integer")
+    }
+    config.BlobDownloadAttempts = n
+  }
+
+  return config
 }
 
+// Task is the JSON task Overview sends us. We pass it to /app/convert
+// verbatim.
+type Task = schema.Task
+
 func generateMimeBoundary() []byte {
   // https://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-golang
   const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -37,17 +183,313 @@ func generateMimeBoundary() []byte {
   return ret
 }
 
-func runConvert(task Task, jsonBytes []byte) {
-  blobResp, err := http.Get(task.Blob.Url)
+// closeDelimiterReader passes /app/convert's output through to Overview.
+//
+// When we interrupt /app/convert, or when it exits with an error, it stops
+// writing mid-stream. In that case, closeDelimiterReader appends a close
+// delimiter (if /app/convert did not write one), so Overview always receives
+// well-formed multipart/form-data. If we interrupted with an error message,
+// or /app/convert failed, it appends an "error" fragment first.
+type closeDelimiterReader struct {
+  reader io.ReadCloser
+  mimeBoundary string
+  waitForExit func() error // waits for /app/convert to exit, once reader is at EOF
+  closeDelimiter []byte // "--MIME-BOUNDARY--"
+  tail []byte           // last len(closeDelimiter) bytes read from reader
+  trailer []byte        // bytes to output after reader's EOF
+  readerDone bool
+
+  lock sync.Mutex       // guards the fields below
+  interrupted bool      // true once we interrupted /app/convert
+  errorMessage string   // message for "error" fragment, or "" for none
+  finished bool         // true once reader returned EOF
+}
+
+func newCloseDelimiterReader(reader io.ReadCloser, mimeBoundary string, waitForExit func() error) *closeDelimiterReader {
+  return &closeDelimiterReader{
+    reader: reader,
+    mimeBoundary: mimeBoundary,
+    waitForExit: waitForExit,
+    closeDelimiter: []byte("--" + mimeBoundary + "--"),
+  }
+}
+
+// markInterrupted makes us end the output with a close delimiter. If
+// errorMessage is not "", we'll output an "error" fragment before it.
+//
+// The first errorMessage wins.
+func (r *closeDelimiterReader) markInterrupted(errorMessage string) {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  if !r.interrupted {
+    r.interrupted = true
+    r.errorMessage = errorMessage
+  }
+}
+
+func (r *closeDelimiterReader) isFinished() bool {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  return r.finished
+}
+
+func (r *closeDelimiterReader) rememberTail(b []byte) {
+  r.tail = append(r.tail, b...)
+  if extra := len(r.tail) - len(r.closeDelimiter); extra > 0 {
+    r.tail = append(r.tail[:0], r.tail[extra:]...)
+  }
+}
+
+func (r *closeDelimiterReader) buildTrailer() []byte {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+
+  r.finished = true
+  if !r.interrupted || bytes.Equal(r.tail, r.closeDelimiter) {
+    return nil
+  }
+
+  var trailer bytes.Buffer // writes never fail
+  fragments := converter.FragmentWriter{Writer: &trailer, MimeBoundary: r.mimeBoundary}
+  if r.errorMessage != "" {
+    fragments.WriteStringFragment("error", r.errorMessage)
+  }
+  fragments.WriteCloseDelimiter()
+  return trailer.Bytes()
+}
+
+func (r *closeDelimiterReader) Read(p []byte) (int, error) {
+  if !r.readerDone {
+    n, err := r.reader.Read(p)
+    r.rememberTail(p[:n])
+    if err != io.EOF {
+      return n, err
+    }
+
+    r.readerDone = true
+    if err := r.waitForExit(); err != nil {
+      // If we interrupted /app/convert, that explains the error
+      r.markInterrupted(convertErrorMessage(err))
+    }
+    r.trailer = r.buildTrailer()
+    if n > 0 {
+      return n, nil
+    }
+  }
+
+  if len(r.trailer) == 0 {
+    return 0, io.EOF
+  }
+  n := copy(p, r.trailer)
+  r.trailer = r.trailer[n:]
+  return n, nil
+}
+
+func (r *closeDelimiterReader) Close() error {
+  if err := r.reader.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+    return err
+  }
+  return nil // maybe waitForExit() closed it already
+}
+
+// convertErrorMessage explains err, from cmd.Wait(), to Overview's users.
+func convertErrorMessage(err error) string {
+  if exitErr, ok := err.(*exec.ExitError); ok {
+    if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+      if status.Signaled() {
+        return fmt.Sprintf("converter was killed by signal %s", status.Signal())
+      }
+      return fmt.Sprintf("converter exited with status %d", status.ExitStatus())
+    }
+  }
+  return "converter failed: " + err.Error()
+}
+
+// isTaskCanceled asks Overview whether it still wants the task at taskUrl.
+//
+// Overview responds to `HEAD taskUrl` with 404 Not Found or 410 Gone once the
+// task is canceled. Any other response (or no response at all) means we should
+// keep converting.
+func isTaskCanceled(client *http.Client, taskUrl string, logger *log.Logger) bool {
+  resp, err := client.Head(taskUrl)
   if err != nil {
-    log.Printf("GET %s: %s", task.Blob.Url, err)
+    logger.Printf("HEAD %s: %s", taskUrl, err)
+    return false
+  }
+  resp.Body.Close()
+  return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+}
+
+// watchForCancel polls Overview every `interval` until `done` is closed. It
+// calls `interrupt("")` if Overview says the task was canceled.
+//
+// Each poll times out after `interval`: a stalled request must not stop us
+// from polling again.
+func watchForCancel(taskUrl string, interval time.Duration, done <-chan struct{}, interrupt func(string), logger *log.Logger) {
+  if interval <= 0 {
+    return
+  }
+
+  client := &http.Client{Timeout: interval}
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-done:
+      return
+    case <-ticker.C:
+      if isTaskCanceled(client, taskUrl, logger) {
+        logger.Printf("Overview canceled the task; interrupting /app/convert")
+        interrupt("")
+        return
+      }
+    }
+  }
+}
+
+// Worker polls for tasks and converts them, one at a time.
+//
+// We run Config.Concurrency workers side by side. Each has its own log prefix
+// and gives each /app/convert invocation its own temporary directory.
+type Worker struct {
+  Id int // 1, 2, ...
+  Config Config
+  Logger *log.Logger
+  Backoff Backoff
+  Metrics *Metrics // shared by all workers
+  Health *Health   // shared by all workers
+}
+
+func NewWorker(config Config, id int, metrics *Metrics, health *Health) *Worker {
+  prefix := ""
+  if config.Concurrency > 1 {
+    prefix = fmt.Sprintf("[worker %d] ", id)
+  }
+
+  return &Worker{
+    Id: id,
+    Config: config,
+    Logger: log.New(os.Stderr, prefix, 0),
+    Backoff: Backoff{
+      Initial: config.RetryInitialInterval,
+      Max: config.RetryMaxInterval,
+    },
+    Metrics: metrics,
+    Health: health,
+  }
+}
+
+// watchForShutdown waits until `done` is closed. If ctx is canceled first --
+// that is, we're shutting down -- it interrupts /app/convert. If `done` still
+// isn't closed after Config.ShutdownGracePeriod, it kills /app/convert.
+func (w *Worker) watchForShutdown(ctx context.Context, done <-chan struct{}, interrupt func(string), process *os.Process) {
+  select {
+  case <-done:
+    return
+  case <-ctx.Done():
+  }
+
+  w.Logger.Printf("Shutting down; interrupting /app/convert")
+  interrupt("conversion interrupted: converter is shutting down")
+
+  select {
+  case <-done:
+  case <-time.After(w.Config.ShutdownGracePeriod):
+    w.Logger.Printf("/app/convert is still running after %s; killing it", w.Config.ShutdownGracePeriod)
+    process.Kill()
+  }
+}
+
+// conversionTimeout returns how long /app/convert may take to convert `task`,
+// or 0 if it may take forever.
+func (w *Worker) conversionTimeout(task Task) time.Duration {
+  const MB = 1024 * 1024
+  perMB := w.Config.ConversionDeadlinePerMB
+  return w.Config.ConversionDeadline + perMB * time.Duration(task.Blob.NBytes / MB) + perMB * time.Duration(task.Blob.NBytes % MB) / MB
+}
+
+// formatTimeout formats d for humans: "10m" instead of "10m0s".
+func formatTimeout(d time.Duration) string {
+  s := d.String()
+  if strings.HasSuffix(s, "m0s") {
+    s = s[:len(s) - 2]
+  }
+  if strings.HasSuffix(s, "h0m") {
+    s = s[:len(s) - 2]
+  }
+  return s
+}
+
+// watchForTimeout waits until `done` is closed. If `deadline` comes first, it
+// sets `timedOut` and interrupts /app/convert with an error message. If `done`
+// still isn't closed after Config.ShutdownGracePeriod, it kills /app/convert.
+func (w *Worker) watchForTimeout(deadline time.Time, timeout time.Duration, done <-chan struct{}, interrupt func(string), process *os.Process, timedOut *int32) {
+  timer := time.NewTimer(time.Until(deadline))
+  defer timer.Stop()
+
+  select {
+  case <-done:
     return
+  case <-timer.C:
   }
-  defer blobResp.Body.Close()
 
-  log.Printf("converting %s", task.Filename)
+  atomic.StoreInt32(timedOut, 1)
+  w.Logger.Printf("/app/convert is still running after %s; interrupting it", formatTimeout(timeout))
+  interrupt("conversion timed out after " + formatTimeout(timeout))
+
+  select {
+  case <-done:
+  case <-time.After(w.Config.ShutdownGracePeriod):
+    w.Logger.Printf("/app/convert is still running %s after we interrupted it; killing it", w.Config.ShutdownGracePeriod)
+    process.Kill()
+  }
+}
+
+func (w *Worker) runConvert(ctx context.Context, task Task, jsonBytes []byte) *RunError {
+  // The deadline counts from before we download the blob: we stream the blob
+  // to /app/convert, so the download is part of the conversion.
+  timeout := w.conversionTimeout(task)
+  deadline := time.Time{}
+  healthDeadline := time.Time{} // /healthz allows time for us to kill /app/convert
+  if timeout > 0 {
+    deadline = time.Now().Add(timeout)
+    healthDeadline = deadline.Add(w.Config.ShutdownGracePeriod + time.Second)
+  }
+  w.Health.StartConversion(w.Id, healthDeadline)
+  defer w.Health.EndConversion(w.Id)
+
+  verifier := converter.NewChecksumVerifier(task.Blob, "blob", "task")
+
+  blob, err := w.openBlob(ctx, task)
+  if err != nil {
+    // Tell Overview, so the task doesn't wait for a conversion that will
+    // never come
+    errorMessage := "blob download failed: " + err.Error()
+    if ctx.Err() != nil {
+      errorMessage = "conversion interrupted: converter is shutting down"
+    }
+    if _, uploadErr := postError(task.Url, errorMessage); uploadErr != nil {
+      return uploadErr
+    }
+    return permanentError(err, "Could not download blob")
+  }
+  defer blob.Close()
+
+  w.Logger.Printf("converting %s", task.Filename)
+
+  // /app/convert gets a fresh directory of its own, as its working directory
+  // and $TMPDIR. That way, concurrent conversions can't read one another's
+  // files.
+  tempDir, err := ioutil.TempDir("", "overview-run-")
+  if err != nil {
+    return transientError(err, "Could not create temporary directory for /app/convert")
+  }
+  defer os.RemoveAll(tempDir)
 
   mimeBoundary := string(generateMimeBoundary())
+  checkedBlob := &checksumReader{reader: blob, verifier: verifier}
+  input := &countingReader{reader: checkedBlob}
 
   path := "/app/convert"
   args := make([]string, 3)
@@ -57,103 +499,294 @@ func runConvert(task Task, jsonBytes []byte) {
   cmd := exec.Cmd {
     Path: path,
     Args: args,
-    Stdin: blobResp.Body,
+    Dir: tempDir,
+    Env: append(os.Environ(), "TMPDIR=" + tempDir),
+    Stdin: input,
     Stderr: os.Stderr,
   }
 
   stdout, err := cmd.StdoutPipe()
   if err != nil {
-    log.Fatalf("Could not open stdout from /app/convert: %s", err)
+    return transientError(err, "Could not open stdout from /app/convert")
   }
+  // We may call cmd.Wait() only once, and only after we've read all of
+  // stdout. body calls it at EOF; we call it below, in case Overview stopped
+  // reading first.
+  var waitOnce sync.Once
+  var waitErr error
+  wait := func() error {
+    waitOnce.Do(func() { waitErr = cmd.Wait() })
+    return waitErr
+  }
+  body := newCloseDelimiterReader(stdout, mimeBoundary, wait)
+  output := &countingReader{reader: body}
+
+  // cmd.Start() sets cmd.Process before it starts streaming the blob, so
+  // blob and checkedBlob may call interrupt().
+  interrupt := func(errorMessage string) {
+    body.markInterrupted(errorMessage)
+    cmd.Process.Signal(os.Interrupt)
+  }
+  interruptOnInputError := func(errorMessage string) {
+    w.Logger.Printf("%s; interrupting /app/convert", errorMessage)
+    interrupt(errorMessage)
+  }
+  blob.onFailure = interruptOnInputError
+  checkedBlob.onMismatch = interruptOnInputError
+
+  startTime := time.Now()
 
   if err := cmd.Start(); err != nil {
-    log.Fatalf("Could not invoke /app/convert: %s", err)
+    // Probably the image is broken. Don't take on more tasks right away.
+    return transientError(err, "Could not invoke /app/convert")
+  }
+
+  done := make(chan struct{})
+  go watchForCancel(task.Url, w.Config.CancelPollInterval, done, interrupt, w.Logger)
+  go w.watchForShutdown(ctx, done, interrupt, cmd.Process)
+  var timedOut int32 // atomic
+  if !deadline.IsZero() {
+    go w.watchForTimeout(deadline, timeout, done, interrupt, cmd.Process, &timedOut)
   }
 
   // Pipe stdout to url
-  resp, err := http.Post(task.Url, "multipart/form-data; boundary=\"" + mimeBoundary + "\"", stdout)
+  outcome := UploadFailed
+  var uploadErr *RunError
+  resp, err := http.Post(task.Url, "multipart/form-data; boundary=\"" + mimeBoundary + "\"", output)
   if err != nil {
     // Server went away. That's fine ... we'll just return.
-    log.Printf("%s", err)
+    w.Logger.Printf("%s", err)
   } else {
-    // TODO assert HTTP 202 Accepted
+    outcome, uploadErr = checkUploadResponse(resp)
+    if outcome == UploadTaskGone {
+      w.Logger.Printf("Overview responded to our upload with status %s: the task was canceled or deleted", resp.Status)
+    }
     resp.Body.Close()
   }
+  close(done)
 
-  if err := cmd.Wait(); err != nil {
-    log.Fatalf("/app/convert did not return with status code 0. That means it has a bug.")
+  if !body.isFinished() {
+    // Overview stopped reading before /app/convert finished writing. Nobody
+    // will read the rest of the output, so stop producing it.
+    w.Logger.Printf("Overview closed the connection early; interrupting /app/convert")
+    interrupt("")
   }
-}
 
-func tick(pollUrl string, retryTimeout time.Duration) {
-  resp, err := http.Post(pollUrl, "text/plain", strings.NewReader(""))
+  err = wait()
+  w.Metrics.ObserveConversion(outcome, time.Since(startTime), input.NBytes(), output.NBytes())
+  if atomic.LoadInt32(&timedOut) != 0 {
+    return permanentError(err, "conversion timed out after %s", formatTimeout(timeout))
+  }
+  if message := blob.ErrorMessage(); message != "" {
+    return permanentError(nil, "%s", message)
+  }
+  if message := checkedBlob.ErrorMessage(); message != "" {
+    return permanentError(nil, "%s", message)
+  }
   if err != nil {
-    if uerr, ok := err.(*url.Error); ok {
-      if operr, ok := uerr.Err.(*net.OpError); ok {
-        if scerr, ok := operr.Err.(*os.SyscallError); ok {
-          if scerr.Err == syscall.ECONNREFUSED {
-            log.Printf("Connection refused; will retry in %fs", retryTimeout.Seconds())
-            time.Sleep(retryTimeout)
-            return
-          }
-
-          log.Fatalf("Unhandled os.SyscallError: %v", scerr.Err)
-        } else if dnserr, ok := operr.Err.(*net.DNSError); ok && dnserr.IsTemporary {
-          log.Printf("%s; will try in %fs", dnserr.Error(), retryTimeout.Seconds())
-          time.Sleep(retryTimeout)
-          return
-        } else if strings.HasSuffix(operr.Err.Error(), ": no such host") {
-          log.Printf("DNS lookup failed for %s: no such host; will try in %fs", pollUrl, retryTimeout.Seconds())
-          time.Sleep(retryTimeout)
-          return
-        }
-
-        log.Fatalf("Unhandled net.OpError: %v", operr.Err)
-      }
+    if ctx.Err() != nil {
+      // We may have killed it ourselves
+      w.Logger.Printf("/app/convert exited during shutdown: %s", err)
+    } else {
+      return permanentError(err, "/app/convert did not return with status code 0. That means it has a bug")
     }
+  }
+
+  return uploadErr
+}
+
+// sleep waits for duration `d`, or until ctx is canceled.
+func sleep(ctx context.Context, d time.Duration) {
+  select {
+  case <-ctx.Done():
+  case <-time.After(d):
+  }
+}
+
+// tick polls for a task and converts it.
+//
+// It returns nil if there was no task, or if we converted one. Either way,
+// the caller should poll again.
+func (w *Worker) tick(ctx context.Context) *RunError {
+  // Until we have a task, every error is transient: there's no task to skip,
+  // so all we can do is wait and poll again.
+  w.Health.StartTick(w.Id)
 
-    log.Fatalf("Unhandled HTTP error: %#v", err)
+  pollUrl := w.Config.PollUrl
+  req, err := http.NewRequest("POST", pollUrl, strings.NewReader(""))
+  if err != nil {
+    return transientError(err, "Could not create request to POLL_URL")
+  }
+  req.Header.Set("Content-Type", "text/plain")
+  pollStartTime := time.Now()
+  resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+  if err != nil {
+    if ctx.Err() != nil {
+      // We're shutting down
+      return nil
+    }
+    w.Metrics.ObservePoll("error", time.Since(pollStartTime))
+    w.Health.EndPoll(w.Id, false)
+    return transientError(err, "Could not poll for a task")
   }
   defer resp.Body.Close()
+  switch resp.StatusCode {
+  case 201:
+    w.Metrics.ObservePoll("task", time.Since(pollStartTime))
+    w.Health.EndPoll(w.Id, true)
+  case 204:
+    w.Metrics.ObservePoll("no-task", time.Since(pollStartTime))
+    w.Health.EndPoll(w.Id, true)
+  default:
+    w.Metrics.ObservePoll("error", time.Since(pollStartTime))
+    w.Health.EndPoll(w.Id, false)
+  }
   if resp.StatusCode == 204 {
     // This is normal: we long-poll, but only for a few seconds, and then
     // Overview returns 204. Now we're expected to poll again -- that is,
     // restart the loop.
-    //log.Printf("Overview has no tasks for us; retrying...")
-    return
+    //w.Logger.Printf("Overview has no tasks for us; retrying...")
+    return nil
   } else if resp.StatusCode != 201 {
-    log.Fatalf("Overview responded with status %s", resp.Status)
+    return transientError(nil, "POST %s: Overview responded with status %s", pollUrl, resp.Status)
   }
 
   jsonBytes, err := ioutil.ReadAll(resp.Body)
   if err != nil {
-    log.Fatalf("Could not receive JSON task from Overview: %s", err)
+    return transientError(err, "Could not receive JSON task from Overview")
+  }
+
+  task, err := schema.DecodeTask(jsonBytes)
+  if err != nil {
+    return permanentError(err, "Could not parse JSON task from Overview")
+  }
+  if !isHttpUrl(task.Url) {
+    return permanentError(nil, "JSON task from Overview is missing url, or it is not an HTTP(S) URL")
   }
 
-  var task Task
-  jsonDecoder := json.NewDecoder(bytes.NewReader(jsonBytes))
-  if err := jsonDecoder.Decode(&task); err != nil {
-    log.Fatalf("Could not parse JSON task from Overview: %s", err)
+  // From here on, we can tell Overview what's wrong, so it needn't wait for
+  // the task to time out
+  err = task.Validate()
+  if err == nil && task.Blob.Url == "" {
+    err = errors.New("blob.url is missing")
+  }
+  if err != nil {
+    if _, uploadErr := postError(task.Url, "Invalid input JSON: " + err.Error()); uploadErr != nil {
+      return uploadErr
+    }
+    return permanentError(err, "Invalid JSON task from Overview")
   }
 
-  runConvert(task, jsonBytes)
+  return w.runConvert(ctx, task, jsonBytes)
+}
+
+// isHttpUrl returns true if we can send requests to s: that is, it's an
+// absolute http:// or https:// URL. (url.Parse() accepts nearly anything.)
+func isHttpUrl(s string) bool {
+  u, err := url.Parse(s)
+  return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// handleError logs err. If it is Transient, it waits `retryTimeout` (or until
+// ctx is canceled).
+func (w *Worker) handleError(ctx context.Context, err *RunError, retryTimeout time.Duration) {
+  w.Metrics.ObserveError(err.Kind)
+
+  switch err.Kind {
+  case Transient:
+    w.Logger.Printf("%s; will retry in %fs", err, retryTimeout.Seconds())
+    sleep(ctx, retryTimeout)
+  default:
+    w.Logger.Printf("%s; skipping task", err)
+  }
+}
+
+// run polls and converts until ctx is canceled.
+//
+// After consecutive transient errors, it waits longer and longer before
+// polling again.
+func (w *Worker) run(ctx context.Context) {
+  for ctx.Err() == nil {
+    err := w.tick(ctx)
+    if err != nil && err.Kind == Transient {
+      w.handleError(ctx, err, w.Backoff.Next())
+    } else {
+      w.Backoff.Reset()
+      if err != nil {
+        w.handleError(ctx, err, 0)
+      }
+    }
+  }
+}
+
+// serveHttp serves `GET /metrics`, `GET /healthz` and `GET /readyz` on
+// `address`.
+//
+// If it can't listen, it logs the error and returns: that's no reason to stop
+// converting.
+func serveHttp(address string, metrics *Metrics, health *Health) {
+  mux := http.NewServeMux()
+  mux.Handle("/metrics", metrics)
+  mux.HandleFunc("/healthz", health.ServeHealthz)
+  mux.HandleFunc("/readyz", health.ServeReadyz)
+
+  log.Printf("Serving /metrics, /healthz and /readyz on %s", address)
+  if err := http.ListenAndServe(address, mux); err != nil {
+    log.Printf("Could not serve on LISTEN_ADDRESS %s: %s", address, err)
+  }
+}
+
+// handleShutdownSignals cancels ctx on SIGTERM or SIGINT.
+//
+// Workers stop polling, interrupt /app/convert and finish their uploads.
+// If they haven't finished shortly after the grace period, we exit anyway.
+func handleShutdownSignals(shutdown context.CancelFunc, gracePeriod time.Duration) {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+  sig := <-signals
+
+  log.Printf("Received %s; shutting down", sig)
+  shutdown()
+
+  time.Sleep(gracePeriod + time.Second) // a second to finish uploading
+  log.Printf("Workers did not finish within %s; exiting anyway", gracePeriod)
+  os.Exit(1)
 }
 
 func main() {
   log.SetFlags(0)
 
-  pollUrl := os.Getenv("POLL_URL")
-  if pollUrl == "" {
-    panic("You must set POLL_URL before calling this program")
-  }
+  config := configFromEnv()
 
   rand.Seed(time.Now().UnixNano())
 
+  ctx, shutdown := context.WithCancel(context.Background())
+  go handleShutdownSignals(shutdown, config.ShutdownGracePeriod)
+
+  metrics := NewMetrics()
+  health := NewHealth(config)
+  go func() {
+    <-ctx.Done()
+    health.SetShuttingDown()
+  }()
+  if config.ListenAddress != "" {
+    go serveHttp(config.ListenAddress, metrics, health)
+  }
+
   if len(os.Args) > 1 && os.Args[1] == "just-one-tick" {
-    tick(pollUrl, 0 * time.Second)
+    worker := NewWorker(config, 1, metrics, health)
+    if err := worker.tick(ctx); err != nil {
+      worker.handleError(ctx, err, 0 * time.Second)
+    }
   } else {
-    for {
-      tick(pollUrl, retryTimeout)
+    var wg sync.WaitGroup
+    for i := 1; i <= config.Concurrency; i++ {
+      wg.Add(1)
+      go func(worker *Worker) {
+        defer wg.Done()
+        worker.run(ctx)
+      }(NewWorker(config, i, metrics, health))
     }
+    wg.Wait()
   }
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+var PollDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60} // seconds
+var ConversionDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600} // seconds
+
+// Histogram counts observations in Prometheus-style cumulative buckets.
+type Histogram struct {
+  Buckets []float64 // upper bounds, ascending
+  counts []uint64   // counts[i]: observations <= Buckets[i]
+  count uint64
+  sum float64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+  return &Histogram{
+    Buckets: buckets,
+    counts: make([]uint64, len(buckets)),
+  }
+}
+
+func (h *Histogram) observe(value float64) {
+  for i, bound := range h.Buckets {
+    if value <= bound {
+      h.counts[i]++
+    }
+  }
+  h.count++
+  h.sum += value
+}
+
+func (h *Histogram) writeTo(w io.Writer, name string) {
+  for i, bound := range h.Buckets {
+    fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), h.counts[i])
+  }
+  fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+  fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+  fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(f float64) string {
+  return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+  reader io.ReadCloser
+  nBytes int64 // atomic
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+  n, err := r.reader.Read(p)
+  atomic.AddInt64(&r.nBytes, int64(n))
+  return n, err
+}
+
+func (r *countingReader) Close() error {
+  return r.reader.Close()
+}
+
+func (r *countingReader) NBytes() int64 {
+  return atomic.LoadInt64(&r.nBytes)
+}
+
+// Metrics counts what our workers do, so Prometheus can scrape it from
+// `GET /metrics`. All workers share one Metrics.
+type Metrics struct {
+  lock sync.Mutex
+  polls map[string]uint64 // "task", "no-task" or "error" => count
+  tasksConverted map[UploadOutcome]uint64
+  errors map[ErrorKind]uint64
+  inputBytes uint64
+  outputBytes uint64
+  pollDuration *Histogram
+  conversionDuration *Histogram
+}
+
+func NewMetrics() *Metrics {
+  return &Metrics{
+    polls: make(map[string]uint64),
+    tasksConverted: make(map[UploadOutcome]uint64),
+    errors: make(map[ErrorKind]uint64),
+    pollDuration: NewHistogram(PollDurationBuckets),
+    conversionDuration: NewHistogram(ConversionDurationBuckets),
+  }
+}
+
+// ObservePoll records one `POST POLL_URL`. result is "task", "no-task" or
+// "error".
+func (m *Metrics) ObservePoll(result string, duration time.Duration) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  m.polls[result]++
+  m.pollDuration.observe(duration.Seconds())
+}
+
+// ObserveConversion records one run of /app/convert.
+func (m *Metrics) ObserveConversion(outcome UploadOutcome, duration time.Duration, inputBytes int64, outputBytes int64) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  m.tasksConverted[outcome]++
+  m.conversionDuration.observe(duration.Seconds())
+  m.inputBytes += uint64(inputBytes)
+  m.outputBytes += uint64(outputBytes)
+}
+
+func (m *Metrics) ObserveError(kind ErrorKind) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+  m.errors[kind]++
+}
+
+// writeTo writes all metrics in Prometheus text format.
+func (m *Metrics) writeTo(w io.Writer) {
+  m.lock.Lock()
+  defer m.lock.Unlock()
+
+  fmt.Fprintln(w, "# HELP overview_convert_polls_total Requests to POLL_URL, by result.")
+  fmt.Fprintln(w, "# TYPE overview_convert_polls_total counter")
+  for _, result := range []string{"task", "no-task", "error"} {
+    fmt.Fprintf(w, "overview_convert_polls_total{result=\"%s\"} %d\n", result, m.polls[result])
+  }
+
+  fmt.Fprintln(w, "# HELP overview_convert_tasks_polled_total Tasks we received from Overview.")
+  fmt.Fprintln(w, "# TYPE overview_convert_tasks_polled_total counter")
+  fmt.Fprintf(w, "overview_convert_tasks_polled_total %d\n", m.polls["task"])
+
+  fmt.Fprintln(w, "# HELP overview_convert_tasks_converted_total Runs of /app/convert, by how Overview responded to the upload.")
+  fmt.Fprintln(w, "# TYPE overview_convert_tasks_converted_total counter")
+  for _, outcome := range []UploadOutcome{UploadAccepted, UploadTaskGone, UploadRejected, UploadServerError, UploadFailed} {
+    fmt.Fprintf(w, "overview_convert_tasks_converted_total{outcome=\"%s\"} %d\n", outcome, m.tasksConverted[outcome])
+  }
+
+  fmt.Fprintln(w, "# HELP overview_convert_errors_total Errors, by kind.")
+  fmt.Fprintln(w, "# TYPE overview_convert_errors_total counter")
+  for _, kind := range []ErrorKind{Transient, Permanent} {
+    fmt.Fprintf(w, "overview_convert_errors_total{kind=\"%s\"} %d\n", kind, m.errors[kind])
+  }
+
+  fmt.Fprintln(w, "# HELP overview_convert_input_bytes_total Bytes of input we streamed to /app/convert.")
+  fmt.Fprintln(w, "# TYPE overview_convert_input_bytes_total counter")
+  fmt.Fprintf(w, "overview_convert_input_bytes_total %d\n", m.inputBytes)
+
+  fmt.Fprintln(w, "# HELP overview_convert_output_bytes_total Bytes of output we uploaded from /app/convert.")
+  fmt.Fprintln(w, "# TYPE overview_convert_output_bytes_total counter")
+  fmt.Fprintf(w, "overview_convert_output_bytes_total %d\n", m.outputBytes)
+
+  fmt.Fprintln(w, "# HELP overview_convert_poll_duration_seconds How long each request to POLL_URL took.")
+  fmt.Fprintln(w, "# TYPE overview_convert_poll_duration_seconds histogram")
+  m.pollDuration.writeTo(w, "overview_convert_poll_duration_seconds")
+
+  fmt.Fprintln(w, "# HELP overview_convert_conversion_duration_seconds How long each run of /app/convert (including upload) took.")
+  fmt.Fprintln(w, "# TYPE overview_convert_conversion_duration_seconds histogram")
+  m.conversionDuration.writeTo(w, "overview_convert_conversion_duration_seconds")
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+  m.writeTo(w)
+}
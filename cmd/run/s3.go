@@ -0,0 +1,52 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+
+  "github.com/overview/overview-convert-framework/internal/s3"
+)
+
+// newS3BlobSource GETs `s3://bucket/key` from Config.S3.EndpointUrl (with a
+// path-style URL, as MinIO and friends expect), or from AWS (with a
+// virtual-hosted-style URL). It signs each request with AWS Signature
+// Version 4.
+func newS3BlobSource(u *url.URL, config Config) (BlobSource, error) {
+  s3Config := config.S3
+  if s3Config.AccessKeyId == "" || s3Config.SecretAccessKey == "" {
+    return nil, errors.New("s3:// blob URLs need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+  }
+  bucket := u.Host
+  key := strings.TrimPrefix(u.Path, "/")
+  if bucket == "" || key == "" {
+    return nil, fmt.Errorf("s3:// blob URL %q does not look like \"s3://bucket/key\"", u.String())
+  }
+
+  var objectUrl *url.URL
+  if s3Config.EndpointUrl != "" {
+    endpoint, err := url.Parse(s3Config.EndpointUrl)
+    if err != nil || endpoint.Host == "" {
+      return nil, fmt.Errorf("AWS_ENDPOINT_URL %q is not a URL", s3Config.EndpointUrl)
+    }
+    objectUrl = endpoint
+    objectUrl.Path = endpoint.Path + "/" + bucket + "/" + key
+  } else {
+    objectUrl = &url.URL{
+      Scheme: "https",
+      Host: bucket + ".s3." + s3Config.Region + ".amazonaws.com",
+      Path: "/" + key,
+    }
+  }
+  // S3 computes signatures from the path as we send it, so we must encode it
+  // exactly as s3.SignRequest() does
+  objectUrl.RawPath = s3.UriEncode(objectUrl.Path, false)
+
+  return &httpBlobSource{
+    url: objectUrl.String(),
+    sign: func(req *http.Request) { s3.SignRequest(req, s3Config, time.Now()) },
+  }, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+  "bytes"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// blobSourceFactories maps each URL scheme we support to a BlobSource
+// constructor.
+var blobSourceFactories = map[string]func(u *url.URL, config Config) (BlobSource, error){
+  "http": newHttpBlobSource,
+  "https": newHttpBlobSource,
+  "file": newFileBlobSource,
+  "data": newDataBlobSource,
+  "s3": newS3BlobSource,
+}
+
+// newBlobSource returns a BlobSource for the URL Task.Blob.Url.
+func newBlobSource(blobUrl string, config Config) (BlobSource, error) {
+  u, err := url.Parse(blobUrl)
+  if err != nil {
+    return nil, err
+  }
+
+  factory, ok := blobSourceFactories[strings.ToLower(u.Scheme)]
+  if !ok {
+    return nil, fmt.Errorf("unsupported blob URL scheme %q", u.Scheme)
+  }
+  return factory(u, config)
+}
+
+// httpBlobSource GETs a blob over HTTP. It resumes with a Range request,
+// using If-Range so we never stitch together two versions of a blob.
+type httpBlobSource struct {
+  url string
+  sign func(req *http.Request) // nil, or adds credentials to each request
+  validator string             // ETag or Last-Modified of the first response, for If-Range
+}
+
+func newHttpBlobSource(u *url.URL, config Config) (BlobSource, error) {
+  return &httpBlobSource{url: u.String()}, nil
+}
+
+func (s *httpBlobSource) Open(offset int64) (io.ReadCloser, int64, error) {
+  req, err := http.NewRequest("GET", s.url, nil)
+  if err != nil {
+    return nil, 0, err
+  }
+  if offset > 0 {
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+    if s.validator != "" {
+      req.Header.Set("If-Range", s.validator)
+    }
+  }
+  if s.sign != nil {
+    s.sign(req)
+  }
+
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  if offset == 0 {
+    if resp.StatusCode != http.StatusOK {
+      resp.Body.Close()
+      return nil, 0, &blobStatusError{resp.Status, resp.StatusCode}
+    }
+    s.validator = resp.Header.Get("ETag")
+    if s.validator == "" || strings.HasPrefix(s.validator, "W/") {
+      // If-Range needs a strong validator
+      s.validator = resp.Header.Get("Last-Modified")
+    }
+    return resp.Body, resp.ContentLength, nil
+  }
+
+  switch resp.StatusCode {
+  case http.StatusPartialContent:
+    if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+      resp.Body.Close()
+      return nil, 0, fmt.Errorf("server resumed blob with wrong Content-Range %q", resp.Header.Get("Content-Range"))
+    }
+    return resp.Body, resp.ContentLength, nil
+  case http.StatusOK:
+    // The server ignored our Range header -- or, if it sent a new validator,
+    // the blob changed and we can't stitch the two versions together.
+    if s.validator != "" && resp.Header.Get("ETag") != s.validator && resp.Header.Get("Last-Modified") != s.validator {
+      resp.Body.Close()
+      return nil, 0, errors.New("blob changed while we were downloading it")
+    }
+    if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+      resp.Body.Close()
+      return nil, 0, err
+    }
+    size := resp.ContentLength
+    if size >= 0 {
+      size -= offset
+    }
+    return resp.Body, size, nil
+  default:
+    resp.Body.Close()
+    return nil, 0, &blobStatusError{resp.Status, resp.StatusCode}
+  }
+}
+
+// fileBlobSource reads a blob from a file under Config.BlobFileDir -- say, a
+// volume Overview shares with us.
+type fileBlobSource struct {
+  path string
+}
+
+// newFileBlobSource accepts `file:///path` and `file://localhost/path`, as
+// long as the path (following symlinks) is in Config.BlobFileDir.
+func newFileBlobSource(u *url.URL, config Config) (BlobSource, error) {
+  if config.BlobFileDir == "" {
+    return nil, errors.New("file:// blob URLs are disabled; set BLOB_FILE_DIR to allow them")
+  }
+  if u.Host != "" && u.Host != "localhost" {
+    return nil, fmt.Errorf("file:// blob URL has host %q; it must be empty or \"localhost\"", u.Host)
+  }
+
+  dir, err := filepath.EvalSymlinks(config.BlobFileDir)
+  if err != nil {
+    return nil, err
+  }
+  path, err := filepath.EvalSymlinks(u.Path)
+  if err != nil {
+    return nil, err
+  }
+  if !strings.HasPrefix(path, dir + string(filepath.Separator)) {
+    return nil, fmt.Errorf("file %s is not in BLOB_FILE_DIR", u.Path)
+  }
+
+  return &fileBlobSource{path: path}, nil
+}
+
+func (s *fileBlobSource) Open(offset int64) (io.ReadCloser, int64, error) {
+  f, err := os.Open(s.path)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  info, err := f.Stat()
+  if err != nil {
+    f.Close()
+    return nil, 0, err
+  }
+  if _, err := f.Seek(offset, io.SeekStart); err != nil {
+    f.Close()
+    return nil, 0, err
+  }
+  return f, info.Size() - offset, nil
+}
+
+// dataBlobSource holds a blob from a `data:` URL (RFC 2397), for blobs so
+// small Overview sends them inline.
+type dataBlobSource struct {
+  data []byte
+}
+
+// newDataBlobSource decodes `data:[<mediatype>][;base64],<data>`. We ignore
+// the media type: the task says what the blob is.
+func newDataBlobSource(u *url.URL, config Config) (BlobSource, error) {
+  comma := strings.IndexByte(u.Opaque, ',')
+  if comma == -1 {
+    return nil, errors.New("data: blob URL has no comma")
+  }
+  header, encoded := u.Opaque[:comma], u.Opaque[comma + 1:]
+  if u.RawQuery != "" {
+    encoded += "?" + u.RawQuery // url.Parse() split it off
+  }
+
+  decoded, err := url.PathUnescape(encoded)
+  if err != nil {
+    return nil, fmt.Errorf("data: blob URL is not properly percent-encoded: %s", err)
+  }
+  if !strings.HasSuffix(header, ";base64") {
+    return &dataBlobSource{data: []byte(decoded)}, nil
+  }
+
+  data, err := base64.StdEncoding.DecodeString(decoded)
+  if err != nil {
+    return nil, fmt.Errorf("data: blob URL is not valid base64: %s", err)
+  }
+  return &dataBlobSource{data: data}, nil
+}
+
+func (s *dataBlobSource) Open(offset int64) (io.ReadCloser, int64, error) {
+  return ioutil.NopCloser(bytes.NewReader(s.data[offset:])), int64(len(s.data)) - offset, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+  "bytes"
+  "io"
+  "io/ioutil"
+  "net/http"
+
+  "github.com/overview/overview-convert-framework/converter"
+)
+
+const MaxLoggedResponseBytes = 1024 // how much of Overview's error response we log
+
+// UploadOutcome is how Overview responded when we uploaded /app/convert's
+// output.
+type UploadOutcome int
+
+const (
+  UploadAccepted UploadOutcome = iota // 2xx: Overview has our output
+  UploadTaskGone                      // 404 or 410: the task was canceled or deleted
+  UploadRejected                      // other 4xx: Overview did not want our output
+  UploadServerError                   // 5xx: Overview is having trouble
+  UploadFailed                        // we never got a response
+)
+
+func (o UploadOutcome) String() string {
+  switch o {
+  case UploadAccepted:
+    return "accepted"
+  case UploadTaskGone:
+    return "task-gone"
+  case UploadRejected:
+    return "rejected"
+  case UploadServerError:
+    return "server-error"
+  default:
+    return "failed"
+  }
+}
+
+// checkUploadResponse decides what Overview's response to our upload means.
+//
+// When Overview rejects our output, the returned error includes the start of
+// Overview's response body, so we can find out why.
+func checkUploadResponse(resp *http.Response) (UploadOutcome, *RunError) {
+  if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+    return UploadAccepted, nil
+  }
+
+  if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+    return UploadTaskGone, nil
+  }
+
+  body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MaxLoggedResponseBytes))
+  if resp.StatusCode >= 500 {
+    return UploadServerError, transientError(nil, "Overview responded to our upload with status %s: %q", resp.Status, body)
+  }
+  return UploadRejected, permanentError(nil, "Overview responded to our upload with status %s: %q", resp.Status, body)
+}
+
+// postError uploads an output that is nothing but an "error" fragment. We use
+// it when we can't even start /app/convert.
+func postError(taskUrl string, errorMessage string) (UploadOutcome, *RunError) {
+  mimeBoundary := string(generateMimeBoundary())
+  var body bytes.Buffer // writes never fail
+  fragments := converter.FragmentWriter{Writer: &body, MimeBoundary: mimeBoundary}
+  fragments.WriteStringFragment("error", errorMessage)
+  fragments.WriteCloseDelimiter()
+  resp, err := http.Post(taskUrl, "multipart/form-data; boundary=\"" + mimeBoundary + "\"", &body)
+  if err != nil {
+    return UploadFailed, httpError(err, "Could not upload error to Overview")
+  }
+  defer resp.Body.Close()
+  return checkUploadResponse(resp)
+}
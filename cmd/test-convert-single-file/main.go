@@ -18,6 +18,7 @@ import (
 
   "github.com/google/go-cmp/cmp"
   "github.com/google/go-cmp/cmp/cmpopts"
+  "github.com/overview/overview-convert-framework/schema"
 
   _ "image/png"
   _ "image/jpeg"
@@ -196,6 +197,9 @@ func testDoConvertSucceeds(tempDir string, exampleDir string) string {
   if err != nil {
     return fmt.Sprintf("Failed to read %s: %s", jsonPath, err)
   }
+  if _, err := schema.ParseTask(jsonBytes); err != nil {
+    return fmt.Sprintf("%s is not a valid task: %s", jsonPath, err)
+  }
 
   if err := runDoConvert(tempDir, string(jsonBytes)); err != nil {
     return fmt.Sprintf("do-convert-single-file failed to run %s: %s", exampleDir, err)
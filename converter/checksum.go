@@ -0,0 +1,66 @@
+package converter
+
+import (
+  "crypto/sha1"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "hash"
+  "strings"
+
+  "github.com/overview/overview-convert-framework/schema"
+)
+
+// expectedChecksum is a checksum from the task, and the hash that will
+// compute it.
+type expectedChecksum struct {
+  name string     // "SHA-1" or "SHA-256"
+  expected string // lowercase hex
+  hash hash.Hash
+}
+
+// ChecksumVerifier computes the checksums the task specifies (blob.sha1 and
+// blob.sha256, both optional) of everything written to it.
+type ChecksumVerifier struct {
+  checksums []expectedChecksum
+  inputName string // what we verify, e.g., "blob"
+  taskName string  // what specified the checksums, e.g., "task"
+}
+
+// NewChecksumVerifier returns a ChecksumVerifier for blob. blob must be
+// valid: see schema.Blob.Validate().
+//
+// Error messages read, "[inputName] had wrong SHA-1 checksum: computed ...,
+// but [taskName] specified ...".
+func NewChecksumVerifier(blob schema.Blob, inputName string, taskName string) *ChecksumVerifier {
+  verifier := &ChecksumVerifier{inputName: inputName, taskName: taskName}
+
+  for _, c := range []struct{ name string; value string; newHash func() hash.Hash }{
+    {"SHA-1", blob.Sha1, sha1.New},
+    {"SHA-256", blob.Sha256, sha256.New},
+  } {
+    if c.value != "" {
+      verifier.checksums = append(verifier.checksums, expectedChecksum{c.name, strings.ToLower(c.value), c.newHash()})
+    }
+  }
+
+  return verifier
+}
+
+func (v *ChecksumVerifier) Write(p []byte) (int, error) {
+  for _, c := range v.checksums {
+    c.hash.Write(p) // never returns an error
+  }
+  return len(p), nil
+}
+
+// ErrorMessage returns a message describing the first checksum that doesn't
+// match, or "" if they all match.
+func (v *ChecksumVerifier) ErrorMessage() string {
+  for _, c := range v.checksums {
+    if actual := hex.EncodeToString(c.hash.Sum(nil)); actual != c.expected {
+      return fmt.Sprintf("%s had wrong %s checksum: computed %s, but %s specified %s", v.inputName, c.name, actual, v.taskName, c.expected)
+    }
+  }
+  return ""
+}
@@ -0,0 +1,160 @@
+// Package converter lets you write an Overview converter in Go, as a single
+// program that /app/run invokes directly as /app/convert.
+//
+// Your program's main() calls Run() with a function that converts one task:
+//
+//   func main() {
+//     converter.Run(func(ctx context.Context, task converter.Task, input io.Reader, output converter.OutputWriter) error {
+//       ...
+//       return output.Output(converter.Output{Json: []byte("{}"), Blob: pdf})
+//     })
+//   }
+//
+// Run() handles what /app/convert-* handle for do-convert-* programs: it
+// parses and validates the task, checks the input's length and checksums,
+// sends heartbeats, ends the output with "done" or "error" and stops
+// promptly when /app/run interrupts it.
+package converter
+
+import (
+  "context"
+  "io"
+  "log"
+  "os"
+  "os/signal"
+  "time"
+
+  "github.com/overview/overview-convert-framework/schema"
+)
+
+// Task is the task Overview sent us. See the schema package.
+type Task = schema.Task
+
+// ConvertFunc converts one task.
+//
+// `input` is the blob. It returns an error instead of io.EOF if the blob has
+// the wrong length or checksum.
+//
+// Write progress and output documents to `output`. If ConvertFunc returns
+// nil without ending `output`, Run() writes "done"; if it returns an error,
+// Run() writes the error message as "error".
+//
+// When /app/run interrupts us, Run() cancels `ctx`. Return soon after: Run()
+// ignores further output and exits after CONVERT_GRACE_PERIOD.
+type ConvertFunc func(ctx context.Context, task Task, input io.Reader, output OutputWriter) error
+
+// runOutputWriter is the OutputWriter Run() passes to a ConvertFunc. Before
+// "done", it reads the rest of the input; if the input is wrong, it writes
+// that error instead.
+type runOutputWriter struct {
+  *multipartWriter
+  input *inputReader
+}
+
+func (w *runOutputWriter) Done() error {
+  if message := w.input.Drain(); message != "" {
+    if err := w.multipartWriter.Error(message); err != nil {
+      return err
+    }
+    return &inputError{message}
+  }
+  return w.multipartWriter.Done()
+}
+
+type inputError struct {
+  message string
+}
+
+func (e *inputError) Error() string {
+  return e.message
+}
+
+// sendHeartbeats sends a heartbeat whenever `output` is quiet for
+// HeartbeatDelay, until `done` is closed.
+func sendHeartbeats(output *multipartWriter, done <-chan struct{}) {
+  ticker := time.NewTicker(HeartbeatDelay / 10)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-done:
+      return
+    case <-ticker.C:
+      output.heartbeatIfIdle(HeartbeatDelay)
+    }
+  }
+}
+
+// Run converts the task /app/run passes us and exits. It never returns.
+//
+// /app/run invokes /app/convert with MIME-BOUNDARY and the task JSON as
+// arguments, and streams the blob to stdin. Run() writes the output stream to
+// stdout and exits with status 0, even when the conversion fails: it writes
+// an "error" fragment instead.
+func Run(convert ConvertFunc) {
+  log.SetFlags(0)
+
+  if len(os.Args) != 3 {
+    log.Fatalf("Usage: %s MIME-BOUNDARY JSON", os.Args[0])
+  }
+  mimeBoundary := os.Args[1]
+  inputJson := os.Args[2]
+  gracePeriod, err := GracePeriodFromEnv()
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  stdout := &SilenceableStdout{}
+  multipart := newMultipartWriter(stdout, mimeBoundary)
+
+  task, err := schema.ParseTask([]byte(inputJson))
+  if err != nil {
+    endAndExit(multipart.Error("Invalid input JSON: " + err.Error()))
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  converted := make(chan struct{})
+
+  interrupt := make(chan os.Signal, 1)
+  signal.Notify(interrupt, os.Interrupt)
+  go func() {
+    <-interrupt
+    stdout.Silence()
+    cancel()
+    select {
+    case <-converted:
+    case <-time.After(gracePeriod):
+      log.Printf("Converter is still running %s after SIGINT; exiting", gracePeriod)
+    }
+    os.Exit(0)
+  }()
+
+  input := newInputReader(os.Stdin, task.Blob)
+  output := &runOutputWriter{multipart, input}
+  go sendHeartbeats(multipart, converted)
+
+  err = convert(ctx, task, input, output)
+  close(converted)
+  if ctx.Err() != nil {
+    select {} // the interrupt goroutine exits
+  }
+
+  if message := input.ErrorMessage(); message != "" {
+    // Invalid input explains any other error
+    endAndExit(multipart.Error(message))
+  } else if err != nil {
+    endAndExit(multipart.Error(err.Error()))
+  } else {
+    endAndExit(output.Done())
+  }
+}
+
+// endAndExit exits after Run() wrote "done" or "error". `err` is the error
+// from writing it: ErrOutputClosed is fine (the ConvertFunc already ended
+// the output), and an *inputError has been written, too.
+func endAndExit(err error) {
+  if _, ok := err.(*inputError); err != nil && err != ErrOutputClosed && !ok {
+    log.Printf("Error writing output: %s", err)
+    os.Exit(1)
+  }
+  os.Exit(0)
+}
@@ -0,0 +1,111 @@
+package converter
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "sync"
+
+  "github.com/overview/overview-convert-framework/schema"
+)
+
+// InputVerifier counts and checksums everything written to it, so it can
+// check the input against the task's blob.nBytes, blob.sha1 and blob.sha256.
+type InputVerifier struct {
+  nBytesExpected int64
+  nBytes int64
+  checksums *ChecksumVerifier
+}
+
+// NewInputVerifier returns an InputVerifier for blob. blob must be valid: see
+// schema.Blob.Validate().
+func NewInputVerifier(blob schema.Blob) *InputVerifier {
+  return &InputVerifier{
+    nBytesExpected: blob.NBytes,
+    checksums: NewChecksumVerifier(blob, "Input", "input JSON"),
+  }
+}
+
+func (v *InputVerifier) Write(p []byte) (int, error) {
+  v.nBytes += int64(len(p))
+  return v.checksums.Write(p)
+}
+
+// ErrorMessage returns a message describing what is wrong with the input
+// written so far, or "" if it is complete and correct.
+func (v *InputVerifier) ErrorMessage() string {
+  if v.nBytes != v.nBytesExpected {
+    return fmt.Sprintf("Input had wrong length: read %d bytes, but input JSON specified %d bytes", v.nBytes, v.nBytesExpected)
+  }
+  return v.checksums.ErrorMessage()
+}
+
+// inputReader reads the blob from stdin and checks it against the task's
+// blob.nBytes, blob.sha1 and blob.sha256.
+//
+// If the input is wrong, Read() returns an error instead of io.EOF, and Run()
+// outputs that error instead of whatever the converter makes of it.
+type inputReader struct {
+  reader io.Reader
+  verifier *InputVerifier
+  eof bool // true once reader returned io.EOF (and we checked the input)
+
+  lock sync.Mutex
+  errorMessage string
+}
+
+func newInputReader(reader io.Reader, blob schema.Blob) *inputReader {
+  return &inputReader{
+    reader: reader,
+    verifier: NewInputVerifier(blob),
+  }
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+  if r.eof {
+    return 0, r.endError()
+  }
+
+  n, err := r.reader.Read(p)
+  r.verifier.Write(p[:n])
+
+  if err == io.EOF {
+    r.eof = true
+    message := r.verifier.ErrorMessage()
+    r.lock.Lock()
+    r.errorMessage = message
+    r.lock.Unlock()
+    return n, r.endError()
+  }
+  return n, err
+}
+
+// endError returns what Read() returns at the end of the input: io.EOF, or
+// an error if the input is wrong.
+func (r *inputReader) endError() error {
+  if message := r.ErrorMessage(); message != "" {
+    return errors.New(message)
+  }
+  return io.EOF
+}
+
+// Drain reads whatever the converter didn't, so we can check the whole
+// input. It returns the input error, or "" if the input is correct.
+//
+// Call it only after the converter is done reading.
+func (r *inputReader) Drain() string {
+  if _, err := io.Copy(ioutil.Discard, r); err != nil && r.ErrorMessage() == "" {
+    r.lock.Lock()
+    r.errorMessage = "Error reading input: " + err.Error()
+    r.lock.Unlock()
+  }
+  return r.ErrorMessage()
+}
+
+// ErrorMessage returns the input error, or "" if there was none (yet).
+func (r *inputReader) ErrorMessage() string {
+  r.lock.Lock()
+  defer r.lock.Unlock()
+  return r.errorMessage
+}
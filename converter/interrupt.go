@@ -0,0 +1,57 @@
+package converter
+
+import (
+  "fmt"
+  "os"
+  "sync"
+  "time"
+)
+
+// DefaultGracePeriod is how long a converter may keep running after SIGINT:
+// a ConvertFunc before Run() exits, or do-convert-* before /app/convert-*
+// kills it. It must be shorter than /app/run's SHUTDOWN_GRACE_PERIOD.
+const DefaultGracePeriod = 5 * time.Second
+
+// HeartbeatDelay is how long a converter may be quiet before we send a
+// heartbeat.
+const HeartbeatDelay = 1500 * time.Millisecond
+
+// SilenceableStdout is os.Stdout, until Silence().
+//
+// After SIGINT, Overview ignores our output. But the converter may not
+// notice right away: it may keep writing, or try to output an error.
+type SilenceableStdout struct {
+  lock sync.Mutex
+  silenced bool
+}
+
+func (w *SilenceableStdout) Write(p []byte) (int, error) {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+  if w.silenced {
+    return len(p), nil
+  }
+  return os.Stdout.Write(p)
+}
+
+// Silence makes every future Write() a no-op. It waits for any Write() in
+// progress to finish.
+func (w *SilenceableStdout) Silence() {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+  w.silenced = true
+}
+
+// GracePeriodFromEnv reads CONVERT_GRACE_PERIOD, or returns
+// DefaultGracePeriod if it is unset.
+func GracePeriodFromEnv() (time.Duration, error) {
+  s := os.Getenv("CONVERT_GRACE_PERIOD")
+  if s == "" {
+    return DefaultGracePeriod, nil
+  }
+  d, err := time.ParseDuration(s)
+  if err != nil || d < 0 {
+    return 0, fmt.Errorf("Invalid CONVERT_GRACE_PERIOD: %q does not look like \"5s\" or \"500ms\"", s)
+  }
+  return d, nil
+}
@@ -0,0 +1,85 @@
+package converter
+
+import (
+  "io"
+  "strings"
+)
+
+// FragmentWriter writes the multipart/form-data (RFC 7578) fragments of a
+// converter's output. Both /app/convert-* programs and OutputWriter use it.
+//
+// Each fragment begins with a delimiter, "\r\n--MIME-BOUNDARY": RFC 2046
+// counts the CRLF as part of the delimiter. So the output begins with an
+// empty line (an empty "preamble", which is valid), and each fragment's
+// contents end where the next delimiter begins. That lets
+// /app/convert-stream-to-mime-multipart write a fragment after output from
+// do-convert-stream-to-mime-multipart without knowing how it ended.
+type FragmentWriter struct {
+  Writer io.Writer
+  MimeBoundary string
+}
+
+// nameQuoter percent-encodes the characters a quoted field name can't hold,
+// as RFC 7578 section 4.2 (via the HTML spec) suggests.
+var nameQuoter = strings.NewReplacer("\"", "%22", "\r", "%0D", "\n", "%0A")
+
+// QuoteName returns `name` as a quoted-string for Content-Disposition, e.g.,
+// `"0.json"`.
+func QuoteName(name string) string {
+  return "\"" + nameQuoter.Replace(name) + "\""
+}
+
+// ContentType returns the media type of the fragment `name`, or "" for
+// form fields such as "progress" and "error", which are plain text.
+func ContentType(name string) string {
+  switch {
+  case strings.HasSuffix(name, ".json"):
+    return "application/json"
+  case strings.HasSuffix(name, ".blob"):
+    return "application/octet-stream"
+  case strings.HasSuffix(name, "-thumbnail.png"):
+    return "image/png"
+  case strings.HasSuffix(name, "-thumbnail.jpg"):
+    return "image/jpeg"
+  case strings.HasSuffix(name, ".txt"):
+    return "text/plain; charset=utf-8"
+  default:
+    return ""
+  }
+}
+
+// WriteHeader writes the delimiter and headers that begin fragment `name`.
+// Write its contents next.
+func (w *FragmentWriter) WriteHeader(name string) error {
+  header := "\r\n--" + w.MimeBoundary + "\r\nContent-Disposition: form-data; name=" + QuoteName(name) + "\r\n"
+  if contentType := ContentType(name); contentType != "" {
+    header += "Content-Type: " + contentType + "\r\n"
+  }
+  _, err := io.WriteString(w.Writer, header + "\r\n")
+  return err
+}
+
+// WriteFragment writes fragment `name`, copying its contents from
+// `contents` (which may be nil, for an empty fragment).
+func (w *FragmentWriter) WriteFragment(name string, contents io.Reader) error {
+  if err := w.WriteHeader(name); err != nil {
+    return err
+  }
+  if contents != nil {
+    if _, err := io.Copy(w.Writer, contents); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// WriteStringFragment writes fragment `name` with contents `contents`.
+func (w *FragmentWriter) WriteStringFragment(name string, contents string) error {
+  return w.WriteFragment(name, strings.NewReader(contents))
+}
+
+// WriteCloseDelimiter ends the output.
+func (w *FragmentWriter) WriteCloseDelimiter() error {
+  _, err := io.WriteString(w.Writer, "\r\n--" + w.MimeBoundary + "--")
+  return err
+}
@@ -0,0 +1,201 @@
+package converter
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// OutputWriter writes a converter's output: the multipart/form-data stream
+// /app/run uploads to Overview.
+//
+// Write outputs in order, and end with Done() or Error(). After that, every
+// method returns an error. An OutputWriter is safe for concurrent use.
+type OutputWriter interface {
+  // Progress reports that `fraction` (between 0 and 1) of the conversion is
+  // complete. Progress must never move backward: if `fraction` is smaller
+  // than last time, we repeat last time's value.
+  Progress(fraction float64) error
+
+  // ProgressChildren reports that `nProcessed` of `nTotal` output documents
+  // (say, pages) are complete.
+  ProgressChildren(nProcessed int, nTotal int) error
+
+  // ProgressBytes reports that `nProcessed` of `nTotal` input bytes are
+  // processed.
+  ProgressBytes(nProcessed int64, nTotal int64) error
+
+  // Heartbeat tells Overview we're still alive. Run() sends heartbeats when
+  // the converter is quiet, so you rarely need this.
+  Heartbeat() error
+
+  // Output writes the next output document: N.json, N.blob and (if set)
+  // N-thumbnail.jpg, N-thumbnail.png and N.txt. N starts at 0.
+  Output(output Output) error
+
+  // Error ends the stream with an error message for the user.
+  Error(message string) error
+
+  // Done ends the stream successfully.
+  Done() error
+}
+
+// Output is one output document.
+type Output struct {
+  Json []byte              // N.json: the document's metadata (required)
+  Blob io.Reader           // N.blob: the document's contents, e.g., a PDF (required)
+  ThumbnailJpeg io.Reader  // N-thumbnail.jpg, or nil
+  ThumbnailPng io.Reader   // N-thumbnail.png, or nil
+  Text io.Reader           // N.txt, or nil
+}
+
+// ErrOutputClosed means the OutputWriter already wrote "done" or "error".
+var ErrOutputClosed = errors.New("output is closed: it already ended with \"done\" or \"error\"")
+
+// multipartWriter is our OutputWriter.
+type multipartWriter struct {
+  writer io.Writer
+  fragments FragmentWriter // writes to the multipartWriter itself, via Write()
+
+  lock sync.Mutex
+  closed bool
+  writeErr error         // the first error from writer; we return it forever
+  lastWrite time.Time    // for heartbeatIfIdle()
+  progress FractionProgress
+  nOutputs int           // how many output documents we've written
+}
+
+// NewOutputWriter returns an OutputWriter that writes to `w`, delimiting
+// fragments with `mimeBoundary`.
+func NewOutputWriter(w io.Writer, mimeBoundary string) OutputWriter {
+  return newMultipartWriter(w, mimeBoundary)
+}
+
+func newMultipartWriter(w io.Writer, mimeBoundary string) *multipartWriter {
+  mw := &multipartWriter{writer: w, lastWrite: time.Now(), progress: FractionProgress{Source: "Converter"}}
+  mw.fragments = FragmentWriter{Writer: mw, MimeBoundary: mimeBoundary}
+  return mw
+}
+
+// Write writes to w.writer, unless it already failed. The caller must hold
+// w.lock.
+func (w *multipartWriter) Write(p []byte) (int, error) {
+  if w.writeErr != nil {
+    return 0, w.writeErr
+  }
+  n, err := w.writer.Write(p)
+  if err != nil {
+    w.writeErr = err
+    return n, err
+  }
+  w.lastWrite = time.Now()
+  return n, nil
+}
+
+// writeFragment writes a fragment whose contents come from `contents`. The
+// caller must hold w.lock.
+func (w *multipartWriter) writeFragment(name string, contents io.Reader) error {
+  if w.closed {
+    return ErrOutputClosed
+  }
+  if err := w.fragments.WriteHeader(name); err != nil {
+    return err
+  }
+  if contents != nil {
+    if _, err := io.Copy(w, contents); err != nil {
+      return fmt.Errorf("writing %s: %s", name, err)
+    }
+  }
+  return nil
+}
+
+// writeStringFragment writes a fragment, locking w.lock.
+func (w *multipartWriter) writeStringFragment(name string, contents string) error {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+  return w.writeFragment(name, strings.NewReader(contents))
+}
+
+// end writes a final fragment and the close delimiter.
+func (w *multipartWriter) end(name string, contents string) error {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+  if err := w.writeFragment(name, strings.NewReader(contents)); err != nil {
+    return err
+  }
+  w.closed = true
+  return w.fragments.WriteCloseDelimiter()
+}
+
+func (w *multipartWriter) Progress(fraction float64) error {
+  w.lock.Lock()
+  fraction, err := w.progress.Track(fraction, FormatFraction(fraction))
+  w.lock.Unlock()
+  if err != nil {
+    return err
+  }
+
+  return w.writeStringFragment("progress", FormatFraction(fraction))
+}
+
+func (w *multipartWriter) ProgressChildren(nProcessed int, nTotal int) error {
+  return w.writeStringFragment("progress", fmt.Sprintf("{\"children\":{\"nProcessed\":%d,\"nTotal\":%d}}", nProcessed, nTotal))
+}
+
+func (w *multipartWriter) ProgressBytes(nProcessed int64, nTotal int64) error {
+  return w.writeStringFragment("progress", fmt.Sprintf("{\"bytes\":{\"nProcessed\":%d,\"nTotal\":%d}}", nProcessed, nTotal))
+}
+
+func (w *multipartWriter) Heartbeat() error {
+  return w.writeStringFragment("heartbeat", "")
+}
+
+// heartbeatIfIdle sends a heartbeat if we haven't written anything for
+// `delay`. It does nothing while another fragment is being written.
+func (w *multipartWriter) heartbeatIfIdle(delay time.Duration) {
+  w.lock.Lock()
+  defer w.lock.Unlock()
+  if !w.closed && time.Since(w.lastWrite) >= delay {
+    w.writeFragment("heartbeat", nil)
+  }
+}
+
+func (w *multipartWriter) Output(output Output) error {
+  if output.Json == nil || output.Blob == nil {
+    return errors.New("Output needs Json and Blob")
+  }
+
+  w.lock.Lock()
+  defer w.lock.Unlock()
+
+  n := strconv.Itoa(w.nOutputs)
+  for _, fragment := range []struct{ name string; contents io.Reader }{
+    {n + ".json", bytes.NewReader(output.Json)},
+    {n + ".blob", output.Blob},
+    {n + "-thumbnail.jpg", output.ThumbnailJpeg},
+    {n + "-thumbnail.png", output.ThumbnailPng},
+    {n + ".txt", output.Text},
+  } {
+    if fragment.contents != nil {
+      if err := w.writeFragment(fragment.name, fragment.contents); err != nil {
+        return err
+      }
+    }
+  }
+
+  w.nOutputs++
+  return nil
+}
+
+func (w *multipartWriter) Error(message string) error {
+  return w.end("error", message)
+}
+
+func (w *multipartWriter) Done() error {
+  return w.end("done", "")
+}
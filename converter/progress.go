@@ -0,0 +1,62 @@
+package converter
+
+import (
+  "fmt"
+  "log"
+  "math"
+  "regexp"
+  "strconv"
+)
+
+// fractionRegex matches decimal numbers: "0", "0.5", ".5", "1.0", "5e-1".
+// It doesn't match "0x5", "NaN", "Inf" or "-0.5".
+var fractionRegex = regexp.MustCompile("^(?:\\d+(?:\\.\\d*)?|\\.\\d+)(?:[eE][-+]?\\d+)?$")
+
+// FractionProgress validates fractional progress.
+//
+// Progress must be between 0 and 1, and it must never move backward:
+// Overview's progress bar would jump around. If it does move backward, we
+// repeat the previous value.
+type FractionProgress struct {
+  Source string  // who reports progress, for messages, e.g., "do-convert-single-file"
+  last float64   // largest fraction so far
+}
+
+// LooksLikeFraction returns true if line is a decimal number -- that is,
+// the converter meant it as progress, not as an error message.
+func LooksLikeFraction(line string) bool {
+  return fractionRegex.MatchString(line)
+}
+
+// Parse parses a line that LooksLikeFraction(). See Track().
+func (p *FractionProgress) Parse(line string) (float64, error) {
+  fraction, err := strconv.ParseFloat(line, 64)
+  if err != nil && fraction != math.Inf(1) { // "1e999" is +Inf: out of range
+    return 0, fmt.Errorf("%s reported progress %s, which is not a number", p.Source, line)
+  }
+  return p.Track(fraction, line)
+}
+
+// Track returns `fraction`, or the largest fraction so far if that is
+// larger. It returns an error if `fraction` is not between 0 and 1.
+//
+// `description` is how p.Source wrote `fraction`.
+func (p *FractionProgress) Track(fraction float64, description string) (float64, error) {
+  if !(fraction >= 0 && fraction <= 1) { // also catches NaN
+    return 0, fmt.Errorf("%s reported progress %s, which is not between 0 and 1", p.Source, description)
+  }
+
+  if fraction < p.last {
+    log.Printf("%s reported progress %s after %s; progress must not move backward", p.Source, description, FormatFraction(p.last))
+    return p.last, nil
+  }
+
+  p.last = fraction
+  return fraction, nil
+}
+
+// FormatFraction formats a fraction the way Overview expects progress:
+// "0.5", not "5e-01".
+func FormatFraction(fraction float64) string {
+  return strconv.FormatFloat(fraction, 'f', -1, 64)
+}
@@ -0,0 +1,204 @@
+// Package doconvert runs a /app/convert-* program's child,
+// /app/do-convert-*, and cleans up after it: it deletes the temporary
+// directory the child ran in and kills any processes the child left behind,
+// however we exit.
+//
+// Each /app/convert-* invocation runs at most one child, so this package
+// keeps its state in globals.
+package doconvert
+
+import (
+  "io/ioutil"
+  "log"
+  "os"
+  "os/exec"
+  "sync"
+  "syscall"
+  "time"
+
+  "github.com/overview/overview-convert-framework/converter"
+  "github.com/overview/overview-convert-framework/internal/limits"
+)
+
+// Stdout is os.Stdout, until HandleInterrupt() silences it: while we wait for
+// our child to exit, the main goroutine may notice and try to output an
+// error.
+var Stdout = &converter.SilenceableStdout{}
+
+// GracePeriod is how long we wait for the child to exit after SIGINT before
+// we kill it. Set it (with GracePeriodFromEnv()) first thing.
+var GracePeriod = converter.DefaultGracePeriod
+
+// tempDirToDelete is this invocation's private working directory. Exit()
+// deletes it.
+var tempDirToDelete string
+
+// convertCmd is the running child, once Start() starts it. convertName
+// describes it in log messages.
+//
+// stopConvert() holds convertCmdLock until exit, so nobody can start it
+// after we begin exiting.
+var convertCmd *exec.Cmd
+var convertName string
+var convertCmdLock sync.Mutex
+
+// convertExited is closed once convertCmd exits, after waitForExit() sets
+// convertState or convertWaitErr.
+//
+// waitForExit() is the only goroutine that waits for convertCmd: both
+// HandleInterrupt() and Wait() need to know when it exits, and a process can
+// be waited for only once.
+var convertExited = make(chan struct{})
+var convertState *os.ProcessState
+var convertWaitErr error
+
+// exitLock is held by whichever goroutine is exiting. It is never unlocked:
+// other goroutines that try to exit will block until the process ends.
+var exitLock sync.Mutex
+
+func deleteTempDir() {
+  if tempDirToDelete != "" {
+    if err := os.RemoveAll(tempDirToDelete); err != nil {
+      log.Printf("Failed to delete %s: %s", tempDirToDelete, err)
+    }
+  }
+}
+
+// stopConvert interrupts the child's process group (if the child is
+// running) and waits for the child to exit. If the child is still running
+// after GracePeriod, or if it left processes behind, it kills them.
+func stopConvert() {
+  convertCmdLock.Lock() // never unlocked: see convertCmd
+  if convertCmd == nil {
+    return
+  }
+
+  select {
+  case <-convertExited:
+  default:
+    syscall.Kill(-convertCmd.Process.Pid, syscall.SIGINT)
+    select {
+    case <-convertExited:
+    case <-time.After(GracePeriod):
+      log.Printf("%s is still running %s after SIGINT; killing it", convertName, GracePeriod)
+    }
+  }
+  killProcessGroup(convertCmd)
+}
+
+// Exit stops the child (see stopConvert()), deletes the temporary directory
+// and then exits.
+//
+// Call this instead of os.Exit() and log.Fatalf(): we must never leave one
+// user's document lying around where another invocation might read it, and
+// we must never leave the child running after we stop reading its output.
+func Exit(code int) {
+  exitLock.Lock()
+  stopConvert()
+  deleteTempDir()
+  os.Exit(code)
+}
+
+// Fatalf logs a message and then calls Exit(1).
+func Fatalf(format string, v ...interface{}) {
+  log.Printf(format, v...)
+  Exit(1)
+}
+
+// CreateTempDir creates an empty directory that only we can read (mode
+// 0700), with a name no other invocation will use. Exit() deletes it.
+func CreateTempDir(prefix string) string {
+  tempDir, err := ioutil.TempDir("", prefix)
+  if err != nil {
+    log.Fatalf("Failed to create temporary directory: %s", err)
+  }
+  tempDirToDelete = tempDir
+  return tempDir
+}
+
+// LimitsFromEnv reads resource limits for do-convert-*. If one is invalid,
+// it exits.
+func LimitsFromEnv() []limits.Limit {
+  convertLimits, err := limits.FromEnv()
+  if err != nil {
+    Fatalf("%s", err)
+  }
+  return convertLimits
+}
+
+// GracePeriodFromEnv reads CONVERT_GRACE_PERIOD. If it is invalid, it exits.
+func GracePeriodFromEnv() time.Duration {
+  gracePeriod, err := converter.GracePeriodFromEnv()
+  if err != nil {
+    Fatalf("%s", err)
+  }
+  return gracePeriod
+}
+
+// Start starts cmd, which must run in its own process group
+// (SysProcAttr.Setpgid). name describes it in log messages.
+//
+// It returns cmd.Start()'s error. Once we are exiting, it never returns.
+func Start(cmd *exec.Cmd, name string) error {
+  convertCmdLock.Lock()
+  defer convertCmdLock.Unlock()
+
+  if err := cmd.Start(); err != nil {
+    return err
+  }
+  convertCmd = cmd
+  convertName = name
+  go waitForExit(cmd)
+  return nil
+}
+
+// waitForExit waits for cmd to exit, kills any processes it left running
+// and then closes convertExited.
+//
+// It calls cmd.Process.Wait(), not cmd.Wait(): cmd.Wait() would close
+// cmd's stdout while the main goroutine may still be reading it. And it
+// kills leftover processes right away, not after the main goroutine reads
+// EOF: they may have inherited cmd's stdout, so EOF would never come.
+func waitForExit(cmd *exec.Cmd) {
+  convertState, convertWaitErr = cmd.Process.Wait()
+  killProcessGroup(cmd)
+  close(convertExited)
+}
+
+// Wait waits for the child Start() started to exit, and for any processes it
+// left running to be killed. It returns what cmd.Wait() would: nil, an
+// *exec.ExitError or another error.
+func Wait() error {
+  <-convertExited
+  if convertWaitErr != nil {
+    return convertWaitErr
+  }
+  if !convertState.Success() {
+    return &exec.ExitError{ProcessState: convertState}
+  }
+  return nil
+}
+
+// killProcessGroup kills every process the child started that is still
+// running. The child runs in its own process group, and so do they (unless
+// they took pains to leave it).
+func killProcessGroup(cmd *exec.Cmd) {
+  if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+    log.Printf("Could not kill process group %d: %s", cmd.Process.Pid, err)
+  }
+}
+
+// HandleInterrupt waits for SIGINT. Then it stops the child (see
+// stopConvert()) and exits -- ignoring the child's output.
+func HandleInterrupt(interrupt <-chan os.Signal) {
+  <-interrupt
+
+  // Once the program exits, the main goroutine will notice its output is
+  // closed and try to exit with an error. Don't let it: we're exiting first.
+  exitLock.Lock()
+  Stdout.Silence()
+
+  stopConvert()
+  deleteTempDir()
+  os.Exit(0)
+}
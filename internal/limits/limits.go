@@ -0,0 +1,206 @@
+// Package limits applies resource limits (see setrlimit(2)) to the programs
+// /app/convert-* run, and explains how a program ran afoul of them.
+package limits
+
+import (
+  "fmt"
+  "log"
+  "math"
+  "os"
+  "os/exec"
+  "regexp"
+  "strconv"
+  "syscall"
+  "time"
+)
+
+// ExecArg0 is os.Args[0] when we invoke ourselves to apply Limits.
+//
+// Go can't set resource limits on a child process. So we start
+// `/proc/self/exe` with this argv[0]; it calls setrlimit() on itself and
+// then execs the real program, which inherits the limits. Programs that use
+// WrapCommand() must call Exec() first thing when os.Args[0] is ExecArg0.
+const ExecArg0 = "exec-with-limits"
+
+// Limit is a resource limit (see setrlimit(2)) for a do-convert-* program.
+type Limit struct {
+  Resource int        // syscall.RLIMIT_*
+  Value uint64        // bytes, seconds or count, depending on Resource
+  Description string  // for error messages, e.g., "2GB memory"
+}
+
+var sizeRegex = regexp.MustCompile("^(\\d+)\\s*([KMGT]?)B?$")
+
+// parseSize parses "2GB", "512M" or "1048576" into bytes. K, M, G and T are
+// powers of 1024.
+func parseSize(s string) (uint64, error) {
+  g := sizeRegex.FindStringSubmatch(s)
+  if g == nil {
+    return 0, fmt.Errorf("%q does not look like \"2GB\", \"512MB\" or \"1048576\"", s)
+  }
+
+  n, err := strconv.ParseUint(g[1], 10, 64)
+  if err != nil {
+    return 0, err
+  }
+
+  shift := map[string]uint{"": 0, "K": 10, "M": 20, "G": 30, "T": 40}[g[2]]
+  if n > math.MaxUint64 >> shift {
+    return 0, fmt.Errorf("%q is too large", s)
+  }
+  return n << shift, nil
+}
+
+// FromEnv reads CONVERT_MEMORY_LIMIT, CONVERT_CPU_LIMIT,
+// CONVERT_OPEN_FILES_LIMIT and CONVERT_FILE_SIZE_LIMIT. Unset variables mean
+// "no limit".
+func FromEnv() ([]Limit, error) {
+  limits := []Limit{}
+
+  if s := os.Getenv("CONVERT_MEMORY_LIMIT"); s != "" {
+    n, err := parseSize(s)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid CONVERT_MEMORY_LIMIT: %s", err)
+    }
+    limits = append(limits, Limit{syscall.RLIMIT_AS, n, s + " memory"})
+  }
+
+  if s := os.Getenv("CONVERT_CPU_LIMIT"); s != "" {
+    d, err := time.ParseDuration(s)
+    if err != nil || d < time.Second {
+      return nil, fmt.Errorf("Invalid CONVERT_CPU_LIMIT: %q does not look like \"10m\" or \"90s\"", s)
+    }
+    limits = append(limits, Limit{syscall.RLIMIT_CPU, uint64((d + time.Second - 1) / time.Second), s + " CPU time"})
+  }
+
+  if s := os.Getenv("CONVERT_OPEN_FILES_LIMIT"); s != "" {
+    n, err := strconv.ParseUint(s, 10, 64)
+    if err != nil || n == 0 {
+      return nil, fmt.Errorf("Invalid CONVERT_OPEN_FILES_LIMIT: %q is not a positive integer", s)
+    }
+    limits = append(limits, Limit{syscall.RLIMIT_NOFILE, n, s + " open files"})
+  }
+
+  if s := os.Getenv("CONVERT_FILE_SIZE_LIMIT"); s != "" {
+    n, err := parseSize(s)
+    if err != nil {
+      return nil, fmt.Errorf("Invalid CONVERT_FILE_SIZE_LIMIT: %s", err)
+    }
+    limits = append(limits, Limit{syscall.RLIMIT_FSIZE, n, s + " file size"})
+  }
+
+  return limits, nil
+}
+
+func findLimit(limits []Limit, resource int) *Limit {
+  for i := range limits {
+    if limits[i].Resource == resource {
+      return &limits[i]
+    }
+  }
+  return nil
+}
+
+// WrapCommand makes cmd apply `limits` to itself before it runs.
+//
+// cmd.Path and cmd.Args must already be set. Since cmd.Start() will start us
+// instead of cmd.Path, we check here that cmd.Path is executable.
+func WrapCommand(cmd *exec.Cmd, limits []Limit) error {
+  if len(limits) == 0 {
+    return nil
+  }
+  if err := syscall.Access(cmd.Path, 1); err != nil { // 1: X_OK
+    return &os.PathError{Op: "exec", Path: cmd.Path, Err: err}
+  }
+  cmd.Args = append([]string{ExecArg0}, cmd.Args...)
+  cmd.Path = "/proc/self/exe"
+  return nil
+}
+
+// Exec applies FromEnv() to this process and then replaces it with the
+// program `args[0]`. It never returns.
+func Exec(args []string) {
+  limits, err := FromEnv()
+  if err != nil {
+    log.Printf("%s", err)
+    os.Exit(1)
+  }
+
+  for _, limit := range limits {
+    rlimit := syscall.Rlimit{Cur: limit.Value, Max: limit.Value}
+    if limit.Resource == syscall.RLIMIT_CPU {
+      // Send SIGXCPU at the limit, so we can tell why the program died. If it
+      // ignores SIGXCPU, the kernel sends SIGKILL a second later.
+      rlimit.Max++
+    }
+    if err := syscall.Setrlimit(limit.Resource, &rlimit); err != nil {
+      log.Printf("Could not limit %s: %s", limit.Description, err)
+      os.Exit(1)
+    }
+  }
+
+  err = syscall.Exec(args[0], args, os.Environ())
+  log.Printf("Could not start %s: %s", args[0], err)
+  os.Exit(1)
+}
+
+// looksOutOfMemory is true if a program that failed with `status` may have
+// failed because malloc() did. Such programs tend to abort (SIGABRT, e.g.,
+// an uncaught std::bad_alloc), crash (SIGSEGV or SIGBUS, dereferencing NULL),
+// be killed (SIGKILL, by an OOM killer) or exit with status ENOMEM. A shell
+// reports a child killed by signal N as status 128+N.
+func looksOutOfMemory(status syscall.WaitStatus) bool {
+  signals := []syscall.Signal{syscall.SIGABRT, syscall.SIGBUS, syscall.SIGKILL, syscall.SIGSEGV}
+
+  if status.Signaled() {
+    for _, signal := range signals {
+      if status.Signal() == signal {
+        return true
+      }
+    }
+    return false
+  }
+
+  code := status.ExitStatus()
+  if code == int(syscall.ENOMEM) {
+    return true
+  }
+  for _, signal := range signals {
+    if code == 128 + int(signal) {
+      return true
+    }
+  }
+  return false
+}
+
+// ErrorMessage explains how the failed program `name` ran afoul of `limits`,
+// or returns "" if it didn't.
+func ErrorMessage(name string, limits []Limit, state *os.ProcessState) string {
+  status, ok := state.Sys().(syscall.WaitStatus)
+  if !ok || state.Success() {
+    return ""
+  }
+
+  if limit := findLimit(limits, syscall.RLIMIT_CPU); limit != nil {
+    cpuTime := state.UserTime() + state.SystemTime()
+    if (status.Signaled() && status.Signal() == syscall.SIGXCPU) || cpuTime >= time.Duration(limit.Value) * time.Second {
+      return fmt.Sprintf("%s exceeded %s limit", name, limit.Description)
+    }
+  }
+
+  if limit := findLimit(limits, syscall.RLIMIT_FSIZE); limit != nil && status.Signaled() && status.Signal() == syscall.SIGXFSZ {
+    return fmt.Sprintf("%s exceeded %s limit", name, limit.Description)
+  }
+
+  if limit := findLimit(limits, syscall.RLIMIT_AS); limit != nil && looksOutOfMemory(status) {
+    // When a program runs out of address space, malloc() fails. Programs
+    // react in all sorts of ways, so we can only guess.
+    if status.Signaled() {
+      return fmt.Sprintf("%s was killed by signal %s; it may have exceeded its %s limit", name, status.Signal(), limit.Description)
+    } else {
+      return fmt.Sprintf("%s exited with status code %d; it may have exceeded its %s limit", name, status.ExitStatus(), limit.Description)
+    }
+  }
+
+  return ""
+}
@@ -0,0 +1,135 @@
+// Package schema defines the JSON task Overview sends converters.
+//
+// /app/run polls Overview for a task, streams its blob to /app/convert and
+// passes the task JSON along as a command-line argument, and /app/convert
+// passes it to /app/do-convert-*. All of them read it with ParseTask().
+package schema
+
+import (
+  "bytes"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "net/url"
+)
+
+// Task is a conversion job from Overview.
+type Task struct {
+  Id TaskId `json:"id"`
+  Url string `json:"url"`                   // where /app/run uploads output
+  Filename string `json:"filename"`         // the original filename, e.g., "report.docx"
+  ContentType string `json:"contentType"`   // MIME type, e.g., "application/pdf", or ""
+  LanguageCode string `json:"languageCode"` // e.g., "en" or "pt-BR", or ""
+  WantOcr bool `json:"wantOcr"`             // true if the user wants OCR
+  WantSplitByPage bool `json:"wantSplitByPage"` // true if the user wants one document per page
+  Blob Blob `json:"blob"`
+  Metadata json.RawMessage `json:"metadata,omitempty"` // a JSON object, or nil
+}
+
+// Blob describes the input file.
+type Blob struct {
+  Url string `json:"url"`                  // where /app/run downloads the file
+  NBytes int64 `json:"nBytes"`             // file size
+  Sha1 string `json:"sha1,omitempty"`      // hexadecimal checksum, or ""
+  Sha256 string `json:"sha256,omitempty"`  // hexadecimal checksum, or ""
+}
+
+// TaskId is Overview's ID for a task. Overview may send it as a JSON string
+// or a JSON number; either way, we store it as a string.
+type TaskId string
+
+func (id *TaskId) UnmarshalJSON(data []byte) error {
+  var s string
+  if err := json.Unmarshal(data, &s); err == nil {
+    *id = TaskId(s)
+    return nil
+  }
+
+  var n json.Number
+  if err := json.Unmarshal(data, &n); err != nil {
+    return fmt.Errorf("id must be a string or a number, not %s", data)
+  }
+  *id = TaskId(n.String())
+  return nil
+}
+
+// ParseTask decodes and validates task JSON.
+//
+// Fields are optional, because each program needs different ones: /app/run
+// needs url and blob.url; /app/convert needs blob.nBytes. ParseTask only
+// checks that the fields that are present make sense.
+func ParseTask(data []byte) (Task, error) {
+  task, err := DecodeTask(data)
+  if err != nil {
+    return Task{}, err
+  }
+  if err := task.Validate(); err != nil {
+    return Task{}, err
+  }
+  return task, nil
+}
+
+// DecodeTask decodes task JSON without validating it. Use it when you need
+// task.Url to report a validation error.
+func DecodeTask(data []byte) (Task, error) {
+  var task Task
+  if err := json.NewDecoder(bytes.NewReader(data)).Decode(&task); err != nil {
+    return Task{}, err
+  }
+  return task, nil
+}
+
+// Validate returns an error describing the first malformed field, or nil.
+//
+// It ignores descriptive fields -- filename, contentType and languageCode --
+// because converters can do without them. A user's odd language code, such
+// as "pt-BR", must not stop the conversion.
+func (t *Task) Validate() error {
+  if t.Url != "" {
+    if err := validateUrl(t.Url); err != nil {
+      return fmt.Errorf("url is invalid: %s", err)
+    }
+  }
+  if len(t.Metadata) > 0 && string(t.Metadata) != "null" && t.Metadata[0] != '{' {
+    return fmt.Errorf("metadata must be a JSON object, not %s", t.Metadata)
+  }
+  return t.Blob.Validate()
+}
+
+// Validate returns an error describing the first malformed field, or nil.
+func (b *Blob) Validate() error {
+  if b.Url != "" {
+    if err := validateUrl(b.Url); err != nil {
+      return fmt.Errorf("blob.url is invalid: %s", err)
+    }
+  }
+  if b.NBytes < 0 {
+    return fmt.Errorf("blob.nBytes must not be negative; got %d", b.NBytes)
+  }
+  if err := validateHex("blob.sha1", "SHA-1", b.Sha1, 20); err != nil {
+    return err
+  }
+  return validateHex("blob.sha256", "SHA-256", b.Sha256, 32)
+}
+
+func validateUrl(s string) error {
+  u, err := url.Parse(s)
+  if err != nil {
+    return err
+  }
+  if u.Scheme == "" {
+    return fmt.Errorf("%q has no scheme", s)
+  }
+  return nil
+}
+
+// validateHex returns an error unless value is "" or nBytes of hexadecimal.
+func validateHex(field string, name string, value string, nBytes int) error {
+  if value == "" {
+    return nil
+  }
+  if b, err := hex.DecodeString(value); err != nil || len(b) != nBytes {
+    return fmt.Errorf("%s is not a %s checksum in hexadecimal: %q", field, name, value)
+  }
+  return nil
+}
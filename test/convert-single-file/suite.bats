@@ -1,7 +1,7 @@
 #!/usr/bin/env bats
 
-TEST_DIR=/go/src/app/test/convert-single-file
-cmd=/go/src/app/bin/convert-single-file
+TEST_DIR=/go/src/github.com/overview/overview-convert-framework/test/convert-single-file
+cmd=/go/src/github.com/overview/overview-convert-framework/bin/convert-single-file
 
 set_convert_script() {
 	[ -d /app ] || mkdir /app
@@ -18,6 +18,11 @@ input_json() {
   echo '{"blob":{"nBytes":4}}'
 }
 
+is_running() {
+  # A killed process may linger as a zombie if nobody reaps it
+  [ -d /proc/$1 ] && ! grep -q '^State:\s*Z' /proc/$1/status
+}
+
 @test "output 0.json+0.blob+done" {
 	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob'
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
@@ -28,6 +33,26 @@ input_json() {
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/complete-out.mime -
 }
 
+@test "output N.json+N.blob for N=0,1,2" {
+	set_convert_script 'echo -n "{\"a\":0}" > 0.json; echo -n zero > 0.blob; echo -n txt0 > 0.txt; echo -n "{\"a\":1}" > 1.json; echo -n one > 1.blob; echo -n png1 > 1-thumbnail.png; echo -n "{\"a\":2}" > 2.json; echo -n two > 2.blob'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/multiple-out.mime -
+}
+
+@test "output error if output numbers have a gap" {
+	set_convert_script 'echo -n "{\"a\":0}" > 0.json; echo -n zero > 0.blob; echo -n "{\"a\":2}" > 2.json; echo -n two > 2.blob'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-missing-output.mime -
+}
+
+@test "output and delete N.json+N.blob on \"emit N\"" {
+	set_convert_script 'echo -n "{\"a\":0}" > 0.json; echo -n zero > 0.blob; echo -n txt0 > 0.txt; echo emit 0; while [ -e 0.json -o -e 0.blob -o -e 0.txt ]; do sleep 0.01; done; echo -n "{\"a\":1}" > 1.json; echo -n one > 1.blob; echo -n png1 > 1-thumbnail.png; echo emit 1; echo -n "{\"a\":2}" > 2.json; echo -n two > 2.blob'
+	input_blob | timeout 5 $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/multiple-out.mime -
+}
+
+@test "output error on out-of-order \"emit N\"" {
+	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob; echo emit 0; echo emit 2'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-emit-out-of-order.mime -
+}
+
 @test "delete files between invocations" {
 	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob; echo -n txt > 0.txt; echo -n png > 0-thumbnail.png; echo -n jpg > 0-thumbnail.jpg'
 	input_blob | $cmd MIME-BOUNDARY $(input_json) >/dev/null
@@ -35,15 +60,69 @@ input_json() {
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
 }
 
+@test "run in a private directory and delete it afterwards" {
+	set_convert_script 'pwd > /tmp/convert-single-file-dir; stat -c %a . > /tmp/convert-single-file-dir-mode; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  [ "$(cat /tmp/convert-single-file-dir-mode)" = 700 ]
+  [ ! -d "$(cat /tmp/convert-single-file-dir)" ]
+}
+
+@test "delete files after error" {
+	set_convert_script 'pwd > /tmp/convert-single-file-dir; touch input.blob.copy; exit 1'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  [ ! -d "$(cat /tmp/convert-single-file-dir)" ]
+}
+
 @test "output error if input stream has wrong length" {
 	echo -n 'a--' | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-truncated-input.mime -
 }
 
+@test "stream input to stdin when CONVERT_STREAM_INPUT=true" {
+	set_convert_script 'cat > /tmp/convert-single-file-stdin; cmp /tmp/convert-single-file-stdin input.blob && echo -n 42 > 0.json && echo -n bar > 0.blob'
+	input_blob | CONVERT_STREAM_INPUT=true $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+  [ "$(cat /tmp/convert-single-file-stdin)" = blob ]
+}
+
+@test "output error if streamed input has wrong length" {
+	set_convert_script 'cat >/dev/null; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	echo -n 'a--' | CONVERT_STREAM_INPUT=true $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-truncated-input.mime -
+}
+
+@test "write input.blob even if do-convert-single-file ignores stdin" {
+	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | CONVERT_STREAM_INPUT=true $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+}
+
+@test "verify input SHA-1 and SHA-256 checksums" {
+	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | $cmd MIME-BOUNDARY '{"blob":{"nBytes":4,"sha1":"0fd0bcfb44f83e7d5ac7a8922578276b9af48746","sha256":"fa2c8cc4f28176bbeed4b736df569a34c79cd3723e9ec42f9674b4d46ac6b8b8"}}' | diff -u "$TEST_DIR"/simple-out.mime -
+}
+
+@test "output error if input JSON is invalid" {
+	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | $cmd MIME-BOUNDARY '{"blob":{"nBytes":4,"sha1":"xyz"}}' | diff -u "$TEST_DIR"/error-invalid-input-json.mime -
+}
+
+@test "output error if input has wrong checksum" {
+	set_convert_script 'echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | $cmd MIME-BOUNDARY '{"blob":{"nBytes":4,"sha1":"0000000000000000000000000000000000000000"}}' | diff -u "$TEST_DIR"/error-wrong-checksum.mime -
+}
+
+@test "output error if streamed input has wrong checksum" {
+	set_convert_script 'cat >/dev/null; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | CONVERT_STREAM_INPUT=true $cmd MIME-BOUNDARY '{"blob":{"nBytes":4,"sha1":"0000000000000000000000000000000000000000"}}' | diff -u "$TEST_DIR"/error-wrong-checksum.mime -
+}
+
 @test "output error if /app/do-convert-single-file does not exist" {
   rm -f /app/do-convert-single-file
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-no-code.mime -
 }
 
+@test "output no part of any output if a later N.blob does not exist" {
+	set_convert_script 'echo -n "{\"a\":0}" > 0.json; echo -n zero > 0.blob; echo -n "{\"a\":1}" > 1.json'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-missing-later-blob.mime -
+}
+
 @test "output error if 0.blob does not exist" {
 	set_convert_script 'echo -n 42 > 0.json'
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-no-blob.mime -
@@ -54,11 +133,111 @@ input_json() {
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-bad-exit-code.mime -
 }
 
+@test "apply resource limits" {
+	set_convert_script 'ulimit -n > /tmp/open-files-limit; ulimit -v > /tmp/memory-limit; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | CONVERT_OPEN_FILES_LIMIT=64 CONVERT_MEMORY_LIMIT=1GB $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+  [ "$(cat /tmp/open-files-limit)" = 64 ]
+  [ "$(cat /tmp/memory-limit)" = 1048576 ]
+}
+
+@test "output error if script may have exceeded memory limit" {
+	set_convert_script 'kill -ABRT $$'
+	input_blob | CONVERT_MEMORY_LIMIT=1GB $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-memory-limit.mime -
+}
+
+@test "output real exit status if script fails some other way under memory limit" {
+	set_convert_script 'echo -n 42 > 0.json; exit 127'
+	input_blob | CONVERT_MEMORY_LIMIT=1GB $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-bad-exit-code.mime -
+}
+
+@test "output error if script exceeds CPU limit" {
+	set_convert_script 'while true; do true; done'
+	input_blob | CONVERT_CPU_LIMIT=1s $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-cpu-limit.mime -
+}
+
+@test "output error if script exceeds file size limit" {
+	set_convert_script 'exec head -c 2048 /dev/zero > 0.blob'
+	input_blob | CONVERT_FILE_SIZE_LIMIT=1KB $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-file-size-limit.mime -
+}
+
+@test "kill processes left running after exit" {
+	set_convert_script 'sleep 30 >/dev/null 2>&1 & echo $! > /tmp/grandchild-pid; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+  ! is_running $(cat /tmp/grandchild-pid)
+}
+
+@test "finish when a process left running holds stdout open" {
+	set_convert_script 'sleep 30 2>/dev/null & echo $! > /tmp/grandchild-pid; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | timeout 5 $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+  ! is_running $(cat /tmp/grandchild-pid)
+}
+
+@test "kill processes that ignore SIGINT after CONVERT_GRACE_PERIOD" {
+	set_convert_script '(trap "" INT; sleep 30) >/dev/null 2>&1 & echo $! > /tmp/grandchild-pid; trap "" INT; kill -INT $(grep PPid /proc/$$/status | cut -f2); sleep 30'
+	input_blob | CONVERT_GRACE_PERIOD=100ms timeout 5 $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  ! is_running $(cat /tmp/grandchild-pid)
+}
+
+@test "kill do-convert-single-file when we exit before it does" {
+	set_convert_script 'echo $$ > /tmp/child-pid; echo foo; exec sleep 30'
+	input_blob | timeout 5 $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  ! is_running $(cat /tmp/child-pid)
+}
+
 @test "output progress and error events" {
 	set_convert_script 'echo c1/5; echo b20/100; echo 0.523; echo foo'
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/progress-and-error.mime -
 }
 
+@test "output fractional progress from 0 to 1" {
+	set_convert_script 'echo 0; echo .25; echo 5e-1; echo 0.750; echo 1.0; echo 1; echo foo'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/fraction-progress-and-error.mime -
+}
+
+@test "repeat fractional progress instead of moving backward" {
+	set_convert_script 'echo 0.5; echo 0.25; echo foo'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/fraction-progress-backward.mime -
+}
+
+@test "output error if fractional progress is greater than 1" {
+	set_convert_script 'echo 0.5; echo 1.5; echo 0.6'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-fraction-out-of-range.mime -
+}
+
+@test "treat a line that is not a decimal number as an error" {
+	set_convert_script 'echo 0x5; echo 0.6'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-not-a-fraction.mime -
+}
+
+@test "log lines that start with # or warn:" {
+	set_convert_script 'echo "# starting OCR"; echo "warn: page 17 had no text layer"; echo 0.5; echo foo'
+	input_blob | $cmd MIME-BOUNDARY $(input_json) 2>/tmp/convert-single-file-stderr | diff -u "$TEST_DIR"/log-and-warning.mime -
+  grep -q 'starting OCR' /tmp/convert-single-file-stderr
+  grep -q 'warning: page 17 had no text layer' /tmp/convert-single-file-stderr
+}
+
+@test "output warnings when CONVERT_WARNING_FRAGMENTS=true" {
+	set_convert_script 'echo "# starting OCR"; echo "warn: page 17 had no text layer"; echo 0.5; echo foo'
+	input_blob | CONVERT_WARNING_FRAGMENTS=true $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/warning-fragment.mime -
+}
+
+@test "output JSON-lines progress and error events" {
+	set_convert_script 'echo "{\"progress\":{\"children\":{\"nProcessed\":1,\"nTotal\":5}}}"; echo "{\"log\":\"hello\"}"; echo "{\"warning\":\"page 17 had no text layer\"}"; echo "{\"progress\": 0.5}"; echo "{\"error\":\"foo\"}"'
+	input_blob | CONVERT_STDOUT_FORMAT=json-lines $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/json-lines-progress-and-error.mime -
+}
+
+@test "log and ignore invalid JSON-lines progress objects" {
+	set_convert_script 'echo "{\"progress\":{\"children\":{\"nProcessed\":6,\"nTotal\":5}}}"; echo "{\"progress\":{\"bytes\":{\"nProcessed\":-1,\"nTotal\":5}}}"; echo "{\"progress\":{\"bytes\":{\"nProcessed\":1.5,\"nTotal\":5}}}"; echo "{\"progress\":{\"pages\":{\"nProcessed\":1,\"nTotal\":5}}}"; echo "{\"progress\":{\"children\":{\"nTotal\":5}}}"; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | CONVERT_STDOUT_FORMAT=json-lines $cmd MIME-BOUNDARY $(input_json) 2>/tmp/convert-single-file-stderr | diff -u "$TEST_DIR"/simple-out.mime -
+  [ "$(grep -c 'ignoring invalid progress' /tmp/convert-single-file-stderr)" = 5 ]
+}
+
+@test "log non-JSON lines when CONVERT_STDOUT_FORMAT=json-lines" {
+	set_convert_script 'echo stray print statement; echo -n 42 > 0.json; echo -n bar > 0.blob'
+	input_blob | CONVERT_STDOUT_FORMAT=json-lines $cmd MIME-BOUNDARY $(input_json) 2>/tmp/convert-single-file-stderr | diff -u "$TEST_DIR"/simple-out.mime -
+  grep -q 'stray print statement' /tmp/convert-single-file-stderr
+}
+
 @test "output heartbeat when waiting too long" {
   set_convert_script 'sleep 2; echo foo'
   input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/heartbeat-and-error.mime -
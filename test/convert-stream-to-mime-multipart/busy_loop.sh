@@ -0,0 +1,5 @@
+#!/bin/sh
+
+while true; do
+  true
+done
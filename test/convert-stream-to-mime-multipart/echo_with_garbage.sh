@@ -1,6 +1,8 @@
 #!/bin/sh
 
 touch garbage
+pwd > /tmp/garbage-dir
+stat -c %a . > /tmp/garbage-dir-mode
 
 echo -en "--$1\r\nContent-Disposition: form-data; name=0.json\r\n\r\n"
 echo -n "$2"
@@ -0,0 +1,9 @@
+#!/bin/sh
+
+(trap "" INT; sleep 30) >/dev/null 2>&1 &
+echo $! > /tmp/grandchild-pid
+
+cat >/dev/null
+trap "" INT
+kill -INT $(grep PPid /proc/$$/status | cut -f2)
+sleep 30
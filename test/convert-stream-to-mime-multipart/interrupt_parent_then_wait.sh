@@ -1,5 +1,7 @@
 #!/bin/sh
 
+pwd > /tmp/interrupted-dir
+
 do_job() {
   sleep 3
   echo -en "\r\n--$1\r\nContent-Disposition: form-data; name=BAD\r\n\r\n\r\n--$1--"
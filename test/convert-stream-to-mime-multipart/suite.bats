@@ -1,7 +1,7 @@
 #!/usr/bin/env bats
 
-TEST_DIR=/go/src/app/test/convert-stream-to-mime-multipart
-cmd=/go/src/app/bin/convert-stream-to-mime-multipart
+TEST_DIR=/go/src/github.com/overview/overview-convert-framework/test/convert-stream-to-mime-multipart
+cmd=/go/src/github.com/overview/overview-convert-framework/bin/convert-stream-to-mime-multipart
 
 set_convert_script() {
 	[ -d /app ] || mkdir /app
@@ -16,18 +16,33 @@ input_json() {
   echo -n '{"blob":{"nBytes":4}}'
 }
 
+is_running() {
+  # A killed process may linger as a zombie if nobody reaps it
+  [ -d /proc/$1 ] && ! grep -q '^State:\s*Z' /proc/$1/status
+}
+
 @test "output program output" {
   set_convert_script echo
   input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
 }
 
-@test "delete files between invocations" {
+@test "delete files after each invocation" {
 	set_convert_script echo_with_garbage
 	input_blob | $cmd MIME-BOUNDARY $(input_json) >/dev/null
-  [ -f /tmp/*/garbage ]
-  set_convert_script echo
+  [ -n "$(cat /tmp/garbage-dir)" ]
+  [ ! -d "$(cat /tmp/garbage-dir)" ]
+}
+
+@test "run in a private directory" {
+	set_convert_script echo_with_garbage
 	input_blob | $cmd MIME-BOUNDARY $(input_json) >/dev/null
-  [ ! -f /tmp/*/garbage ]
+  [ "$(cat /tmp/garbage-dir-mode)" = 700 ]
+}
+
+@test "delete files after SIGINT" {
+	set_convert_script interrupt_parent_then_wait
+	input_blob | $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  [ ! -d "$(cat /tmp/interrupted-dir)" ]
 }
 
 @test "output error if /app/do-convert-stream-to-mime-multipart does not exist" {
@@ -41,17 +56,52 @@ input_json() {
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-not-executable.mime -
 }
 
+@test "output error if input JSON is invalid" {
+  set_convert_script echo
+	input_blob | $cmd MIME-BOUNDARY '{"blob":{"nBytes":4,"sha1":"xyz"}}' | diff -u "$TEST_DIR"/error-invalid-input-json.mime -
+}
+
 @test "output error if script exits with nonzero status code" {
   # This is what an out-of-memory error looks like
 	set_convert_script exit_127
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-bad-exit-code.mime -
 }
 
+@test "apply resource limits" {
+	set_convert_script write_limits
+	input_blob | CONVERT_OPEN_FILES_LIMIT=64 CONVERT_MEMORY_LIMIT=1GB $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  [ "$(cat /tmp/open-files-limit)" = 64 ]
+  [ "$(cat /tmp/memory-limit)" = 1048576 ]
+}
+
+@test "output error if script exceeds CPU limit" {
+	set_convert_script busy_loop
+	input_blob | CONVERT_CPU_LIMIT=1s $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/error-cpu-limit.mime -
+}
+
 @test "output quickly on SIGINT" {
 	set_convert_script interrupt_parent_then_wait
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/cancel.mime -
 }
 
+@test "kill processes left running after exit" {
+	set_convert_script echo_and_leave_child
+	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+  ! is_running $(cat /tmp/grandchild-pid)
+}
+
+@test "finish when a process left running holds stdout open" {
+	set_convert_script echo_and_leave_child_holding_stdout
+	input_blob | timeout 5 $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
+  ! is_running $(cat /tmp/grandchild-pid)
+}
+
+@test "kill processes that ignore SIGINT after CONVERT_GRACE_PERIOD" {
+	set_convert_script interrupt_parent_and_ignore_sigint
+	input_blob | CONVERT_GRACE_PERIOD=100ms timeout 5 $cmd MIME-BOUNDARY $(input_json) >/dev/null
+  ! is_running $(cat /tmp/grandchild-pid)
+}
+
 @test "add close-delimiter" {
   set_convert_script error_no_close_delimiter
 	input_blob | $cmd MIME-BOUNDARY $(input_json) | diff -u "$TEST_DIR"/simple-out.mime -
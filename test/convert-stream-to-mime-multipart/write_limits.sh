@@ -0,0 +1,6 @@
+#!/bin/sh
+
+ulimit -n > /tmp/open-files-limit
+ulimit -v > /tmp/memory-limit
+
+echo -en "--$1\r\nContent-Disposition: form-data; name=done\r\n\r\n\r\n--$1--"
@@ -0,0 +1,67 @@
+// example-converter is a converter written with the converter package. The
+// test suite picks its behavior with the task's filename.
+package main
+
+import (
+  "bytes"
+  "context"
+  "errors"
+  "io"
+  "io/ioutil"
+  "os"
+  "strings"
+  "syscall"
+  "time"
+
+  "github.com/overview/overview-convert-framework/converter"
+)
+
+func convert(ctx context.Context, task converter.Task, input io.Reader, output converter.OutputWriter) error {
+  switch task.Filename {
+  case "echo":
+    // Output the input as a single document
+    blob, err := ioutil.ReadAll(input)
+    if err != nil {
+      return err
+    }
+    if err := output.Progress(0.5); err != nil {
+      return err
+    }
+    return output.Output(converter.Output{
+      Json: []byte("{\"title\":\"echo\"}"),
+      Blob: bytes.NewReader(blob),
+      Text: strings.NewReader("text"),
+    })
+  case "error":
+    if err := output.ProgressChildren(1, 3); err != nil {
+      return err
+    }
+    return errors.New("conversion failed")
+  case "done":
+    // End the output ourselves; Run() must not write another "done"
+    if err := output.ProgressBytes(4, 4); err != nil {
+      return err
+    }
+    return output.Done()
+  case "slow":
+    time.Sleep(2 * time.Second)
+    return errors.New("conversion failed slowly")
+  case "interrupt":
+    // Interrupt ourselves, as /app/run would
+    output.Progress(0.5)
+    syscall.Kill(os.Getpid(), syscall.SIGINT)
+    <-ctx.Done()
+    output.Progress(1) // ignored
+    ioutil.WriteFile("/tmp/converter-interrupted", []byte(ctx.Err().Error()), 0644)
+    return ctx.Err()
+  case "ignore-interrupt":
+    syscall.Kill(os.Getpid(), syscall.SIGINT)
+    select {}
+  default:
+    return errors.New("unknown filename " + task.Filename)
+  }
+}
+
+func main() {
+  converter.Run(convert)
+}
@@ -0,0 +1,54 @@
+#!/usr/bin/env bats
+
+TEST_DIR=/go/src/github.com/overview/overview-convert-framework/test/converter
+cmd=/tmp/example-converter
+
+input_blob() {
+  echo -n 'blob'
+}
+
+input_json() {
+  echo -n '{"filename":"'"$1"'","blob":{"nBytes":4}}'
+}
+
+@test "output documents and done" {
+  input_blob | $cmd MIME-BOUNDARY $(input_json echo) | diff -u "$TEST_DIR"/simple-out.mime -
+}
+
+@test "output error when convert returns an error" {
+  input_blob | $cmd MIME-BOUNDARY $(input_json error) | diff -u "$TEST_DIR"/progress-and-error.mime -
+}
+
+@test "do not output done twice" {
+  input_blob | $cmd MIME-BOUNDARY $(input_json done) | diff -u "$TEST_DIR"/done.mime -
+}
+
+@test "output heartbeat when convert is quiet" {
+  input_blob | $cmd MIME-BOUNDARY $(input_json slow) | diff -u "$TEST_DIR"/heartbeat-and-error.mime -
+}
+
+@test "output error if input JSON is invalid" {
+  input_blob | $cmd MIME-BOUNDARY '{"filename":"echo","blob":{"nBytes":4,"sha1":"xyz"}}' | diff -u "$TEST_DIR"/error-invalid-input-json.mime -
+}
+
+@test "output error if input is the wrong length" {
+  input_blob | $cmd MIME-BOUNDARY '{"filename":"echo","blob":{"nBytes":5}}' | diff -u "$TEST_DIR"/error-truncated-input.mime -
+}
+
+@test "output error if input the converter did not read is the wrong length" {
+  input_blob | $cmd MIME-BOUNDARY '{"filename":"done","blob":{"nBytes":5}}' | diff -u "$TEST_DIR"/error-unread-input.mime -
+}
+
+@test "output error if input has the wrong checksum" {
+  input_blob | $cmd MIME-BOUNDARY '{"filename":"echo","blob":{"nBytes":4,"sha1":"0000000000000000000000000000000000000000"}}' | diff -u "$TEST_DIR"/error-wrong-checksum.mime -
+}
+
+@test "cancel context and ignore output on SIGINT" {
+  rm -f /tmp/converter-interrupted
+  input_blob | $cmd MIME-BOUNDARY $(input_json interrupt) | diff -u "$TEST_DIR"/cancel.mime -
+  [ "$(cat /tmp/converter-interrupted)" = "context canceled" ]
+}
+
+@test "exit after CONVERT_GRACE_PERIOD if convert ignores SIGINT" {
+  input_blob | CONVERT_GRACE_PERIOD=100ms timeout 5 $cmd MIME-BOUNDARY $(input_json ignore-interrupt) >/dev/null
+}